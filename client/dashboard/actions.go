@@ -108,6 +108,10 @@ func sessionValidationTicks() {
 		defer ticker.Stop()
 
 		for range ticker.C {
+			if streamConnected {
+				continue
+			}
+
 			periodicSessionValidation()
 		}
 	}()
@@ -333,6 +333,7 @@ func installOffcanvasListeners() {
 
 				qrCashInImage.Set("src", "")
 				cashInAmount.Set("value", "")
+				cashInIdempotencyKey = ""
 
 				return nil
 			}),
@@ -383,6 +384,7 @@ func installOffcanvasListeners() {
 
 				qrCashInImage.Set("src", "")
 				cashOutAmount.Set("value", "")
+				withdrawIdempotencyKey = ""
 
 				return nil
 			}),
@@ -5,10 +5,19 @@ package main
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 	"syscall/js"
 	"time"
 )
 
+var (
+	cashInIdempotencyKey   string
+	withdrawIdempotencyKey string
+	transferIdempotencyKey string
+	refundIdempotencyKey   string
+)
+
 func cashInEvent() {
 	amount := getInputValue("cash-in-amount")
 	if amount == "" {
@@ -16,6 +25,10 @@ func cashInEvent() {
 		return
 	}
 
+	if cashInIdempotencyKey == "" {
+		cashInIdempotencyKey = newIdempotencyKey()
+	}
+
 	status, _, content := sendPost(
 		"/api/cashin",
 		map[string]string{
@@ -24,6 +37,7 @@ func cashInEvent() {
 		map[string]interface{}{
 			"X-Session-Token": getSessionKey("session_token"),
 			"X-Security-Code": getSessionKey("security_code"),
+			"Idempotency-Key": cashInIdempotencyKey,
 		},
 	)
 
@@ -32,7 +46,7 @@ func cashInEvent() {
 		return
 	}
 
-	var data map[string]string
+	var data map[string]interface{}
 	err := json.Unmarshal([]byte(content), &data)
 
 	time.Sleep(1 * time.Second)
@@ -42,11 +56,13 @@ func cashInEvent() {
 		showError("cash-in-error", "Internal error occured.")
 		return
 	} else if value, exists := data["status"]; exists && value != "ok" {
-		showError("cash-in-error", capitalizeFirst(data["message"]))
+		message, _ := data["message"].(string)
+		showError("cash-in-error", capitalizeFirst(message))
+
 		return
 	}
 
-	if value, exists := data["transaction_id"]; exists {
+	if value, exists := data["transaction_id"].(string); exists {
 		generateQRCode("cash-in-qr", value)
 
 		mainContentClasses := document.Call(
@@ -67,6 +83,10 @@ func cashInEvent() {
 }
 
 func withdrawEvent() {
+	if withdrawIdempotencyKey == "" {
+		withdrawIdempotencyKey = newIdempotencyKey()
+	}
+
 	status, _, content := sendPost(
 		"/api/withdraw",
 		map[string]string{
@@ -75,6 +95,7 @@ func withdrawEvent() {
 		map[string]interface{}{
 			"X-Session-Token": getSessionKey("session_token"),
 			"X-Security-Code": getSessionKey("security_code"),
+			"Idempotency-Key": withdrawIdempotencyKey,
 		},
 	)
 
@@ -83,7 +104,7 @@ func withdrawEvent() {
 		return
 	}
 
-	var data map[string]string
+	var data map[string]interface{}
 	err := json.Unmarshal([]byte(content), &data)
 
 	time.Sleep(1 * time.Second)
@@ -93,11 +114,13 @@ func withdrawEvent() {
 		showError("cash-out-error", "Internal error occured.")
 		return
 	} else if value, exists := data["status"]; exists && value != "ok" {
-		showError("cash-out-error", capitalizeFirst(data["message"]))
+		message, _ := data["message"].(string)
+		showError("cash-out-error", capitalizeFirst(message))
+
 		return
 	}
 
-	if value, exists := data["transaction_id"]; exists {
+	if value, exists := data["transaction_id"].(string); exists {
 		generateQRCode("cash-out-qr", value)
 
 		mainContentClasses := document.Call(
@@ -117,6 +140,117 @@ func withdrawEvent() {
 	}
 }
 
+func transferEvent() {
+	recipient := getInputValue("transfer-recipient")
+	amount := getInputValue("transfer-amount")
+	currency := strings.ToUpper(getInputValue("transfer-currency"))
+	toCurrency := strings.ToUpper(getInputValue("transfer-to-currency"))
+
+	if recipient == "" || amount == "" {
+		hideLoading("transfer")
+		showError("transfer-error", "Recipient and amount are required.")
+		return
+	}
+
+	if transferIdempotencyKey == "" {
+		transferIdempotencyKey = newIdempotencyKey()
+	}
+
+	status, _, content := sendPost(
+		"/api/payment/transfer",
+		map[string]string{
+			"recipient":   recipient,
+			"amount":      amount,
+			"currency":    currency,
+			"to_currency": toCurrency,
+		},
+		map[string]interface{}{
+			"X-Session-Token": getSessionKey("session_token"),
+			"X-Security-Code": getSessionKey("security_code"),
+			"Idempotency-Key": transferIdempotencyKey,
+		},
+	)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(content), &data)
+
+	time.Sleep(1 * time.Second)
+	hideLoading("transfer")
+
+	if err != nil || status != 200 {
+		showError("transfer-error", "Internal error occured.")
+		return
+	} else if value, exists := data["status"]; exists && value != "ok" {
+		message, _ := data["message"].(string)
+		showError("transfer-error", capitalizeFirst(message))
+
+		return
+	}
+
+	transferIdempotencyKey = ""
+	setInputValue("transfer-recipient", "")
+	setInputValue("transfer-amount", "")
+
+	message := "Sent " + formatCurrency(amount, currency) + " to " + recipient
+	if charge, exists := data["fee"].(float64); exists && charge > 0 {
+		message += " with a fee of " + formatCurrency(strconv.FormatFloat(charge, 'f', 2, 64), currency)
+	}
+
+	showError("transfer-success", message+".")
+	loadInitialInformation()
+}
+
+func refundEvent() {
+	transactionID := getInputValue("refund-transaction")
+	amount := getInputValue("refund-amount")
+
+	if transactionID == "" {
+		hideLoading("refund")
+		showError("refund-error", "Transaction ID is required.")
+		return
+	}
+
+	if refundIdempotencyKey == "" {
+		refundIdempotencyKey = newIdempotencyKey()
+	}
+
+	status, _, content := sendPost(
+		"/api/payment/refund",
+		map[string]string{
+			"transaction_id": transactionID,
+			"amount":         amount,
+		},
+		map[string]interface{}{
+			"X-Session-Token": getSessionKey("session_token"),
+			"X-Security-Code": getSessionKey("security_code"),
+			"Idempotency-Key": refundIdempotencyKey,
+		},
+	)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(content), &data)
+
+	time.Sleep(1 * time.Second)
+	hideLoading("refund")
+
+	if err != nil || status != 200 {
+		showError("refund-error", "Internal error occured.")
+		return
+	} else if value, exists := data["status"]; exists && value != "ok" {
+		message, _ := data["message"].(string)
+		showError("refund-error", capitalizeFirst(message))
+
+		return
+	}
+
+	refundIdempotencyKey = ""
+	setInputValue("refund-transaction", "")
+	setInputValue("refund-amount", "")
+
+	showError("refund-success", "Refund sent.")
+	loadInitialInformation()
+}
+
 func installButtonActions() {
 	securityCode := document.Call(
 		"getElementById",
@@ -139,12 +273,22 @@ func installButtonActions() {
 		"getElementById",
 		"cash-out-btn",
 	)
+	transferButton := document.Call(
+		"getElementById",
+		"transfer-btn",
+	)
+	refundButton := document.Call(
+		"getElementById",
+		"refund-btn",
+	)
 
 	if securityCode.IsNull() || securityCode.IsUndefined() ||
 		showSecurityCodeButton.IsNull() || showSecurityCodeButton.IsUndefined() ||
 		hideSecurityCodeButton.IsNull() || hideSecurityCodeButton.IsUndefined() ||
 		cashInButton.IsNull() || cashInButton.IsUndefined() ||
-		cashOutButton.IsNull() || cashOutButton.IsUndefined() {
+		cashOutButton.IsNull() || cashOutButton.IsUndefined() ||
+		transferButton.IsNull() || transferButton.IsUndefined() ||
+		refundButton.IsNull() || refundButton.IsUndefined() {
 		return
 	}
 
@@ -236,4 +380,26 @@ func installButtonActions() {
 			return nil
 		}),
 	)
+
+	transferButton.Call(
+		"addEventListener",
+		"click",
+		js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			showLoading("transfer")
+			go transferEvent()
+
+			return nil
+		}),
+	)
+
+	refundButton.Call(
+		"addEventListener",
+		"click",
+		js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			showLoading("refund")
+			go refundEvent()
+
+			return nil
+		}),
+	)
 }
@@ -0,0 +1,105 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"syscall/js"
+)
+
+type FeeQuote struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	Fee      Amount `json:"fee"`
+	Total    Amount `json:"total"`
+	Currency string `json:"currency"`
+}
+
+func setFeeText(id string, text string) {
+	element := document.Call("getElementById", id)
+	if element.IsNull() || element.IsUndefined() {
+		return
+	}
+
+	if text == "" {
+		element.Get("classList").Call("remove", "d-block")
+		element.Get("classList").Call("add", "d-none")
+	} else {
+		element.Get("classList").Call("remove", "d-none")
+		element.Get("classList").Call("add", "d-block")
+	}
+
+	element.Set("innerHTML", text)
+}
+
+func showFeeQuote(kind, amountID, currencyID, feeID string) {
+	amount := getInputValue(amountID)
+	if amount == "" {
+		setFeeText(feeID, "")
+		return
+	}
+
+	status, _, content := sendPost(
+		"/api/fees/quote",
+		map[string]string{
+			"kind":     kind,
+			"amount":   amount,
+			"currency": getInputValue(currencyID),
+		},
+		authHeaders(),
+	)
+
+	var quote FeeQuote
+	if status != 200 || json.Unmarshal([]byte(content), &quote) != nil {
+		setFeeText(feeID, "")
+		return
+	}
+
+	if quote.Status != "ok" {
+		setFeeText(feeID, capitalizeFirst(quote.Message)+".")
+		return
+	}
+
+	fee := formatCurrency(quote.Fee.String(), quote.Currency)
+	total := formatCurrency(quote.Total.String(), quote.Currency)
+
+	switch kind {
+	case "cashin":
+		setFeeText(feeID, "Fee: "+fee+". You will receive "+total+".")
+	default:
+		setFeeText(feeID, "Fee: "+fee+". Total deducted: "+total+".")
+	}
+}
+
+func installFeeQuotes() {
+	quotes := []struct {
+		kind     string
+		amountID string
+		currency string
+		feeID    string
+	}{
+		{"cashin", "cash-in-amount", "", "cash-in-fee"},
+		{"withdraw", "cash-out-amount", "", "cash-out-fee"},
+		{"payment", "transfer-amount", "transfer-currency", "transfer-fee"},
+	}
+
+	for _, quote := range quotes {
+		quote := quote
+		for _, id := range []string{quote.amountID, quote.currency} {
+			input := document.Call("getElementById", id)
+			if input.IsNull() || input.IsUndefined() {
+				continue
+			}
+
+			input.Call(
+				"addEventListener",
+				"change",
+				js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+					go showFeeQuote(quote.kind, quote.amountID, quote.currency, quote.feeID)
+					return nil
+				}),
+			)
+		}
+	}
+}
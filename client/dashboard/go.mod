@@ -3,5 +3,3 @@ module github.com/nthnn/ura/client/dashboard
 go 1.23.3
 
 require github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
-
-require github.com/jung-kurt/gofpdf v1.16.2
@@ -0,0 +1,351 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"syscall/js"
+	"time"
+)
+
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Total        int           `json:"total"`
+	HasMore      bool          `json:"has_more"`
+	NextCursor   string        `json:"next_cursor"`
+}
+
+var (
+	loadedTransactions    []Transaction
+	transactionTotal      int
+	nextTransactionCursor string
+	previousPageHash      string
+)
+
+func renderTransaction(id string, transaction Transaction) {
+	htmlContents := `
+	<tr>
+		<td class="p-2">%s</td>
+		<td class="p-2">%s</td>
+		<td class="p-2">%s</td>
+		<td class="p-2 desktop-only" alt="%s">%s</td>
+		<td class="p-2 desktop-only">%s</td>
+	</tr>
+	`
+
+	status := transactionStatus(transaction.Processed)
+
+	formattedTimestamp := transaction.CreatedAt
+	if parsedTime, err := time.Parse(time.RFC3339, transaction.CreatedAt); err == nil {
+		formattedTimestamp = parsedTime.Format("02/01/2006 15:04:05 MST")
+	}
+
+	tbody := js.Global().Get("document").Call(
+		"getElementById",
+		id,
+	)
+	if !tbody.IsNull() && !tbody.IsUndefined() {
+		tidDisplay := transaction.TransactionID
+		if len(tidDisplay) > 12 {
+			tidDisplay = tidDisplay[:12]
+		}
+
+		tbody.Call(
+			"insertAdjacentHTML",
+			"beforeend",
+			fmt.Sprintf(
+				htmlContents,
+				html.EscapeString(transactionCategory(transaction)),
+				formattedTimestamp,
+				html.EscapeString(transactionAmount(transaction)),
+				html.EscapeString(transaction.TransactionID),
+				html.EscapeString(tidDisplay),
+				status,
+			),
+		)
+	}
+}
+
+func transactionFilters(cursor string) map[string]string {
+	return map[string]string{
+		"cursor":   cursor,
+		"category": getInputValue("history-category"),
+		"status":   getInputValue("history-status"),
+		"from":     getInputValue("history-from"),
+		"to":       getInputValue("history-to"),
+	}
+}
+
+func fetchTransactionPage(cursor string) (TransactionPage, string, error) {
+	status, _, content := sendPost(
+		"/api/transactions",
+		transactionFilters(cursor),
+		authHeaders(),
+	)
+
+	if status != 200 {
+		return TransactionPage{}, "", errors.New("server error")
+	}
+
+	var data struct {
+		TransactionPage
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return TransactionPage{}, "", errors.New("failed parsing response data")
+	}
+
+	if data.Status != "ok" {
+		return TransactionPage{}, "", errors.New(capitalizeFirst(data.Message))
+	}
+
+	return data.TransactionPage, toSHA512(content), nil
+}
+
+func renderTransactionTable() {
+	transactionTable := document.Call(
+		"getElementById",
+		"transaction-table",
+	).Get("classList")
+
+	if transactionTable.IsNull() || transactionTable.IsUndefined() {
+		return
+	}
+
+	noTransactionTable := document.Call(
+		"getElementById",
+		"no-transactions",
+	).Get("classList")
+
+	if noTransactionTable.IsNull() || noTransactionTable.IsUndefined() {
+		return
+	}
+
+	if len(loadedTransactions) == 0 {
+		transactionTable.Call("remove", "d-block")
+		transactionTable.Call("add", "d-none")
+
+		noTransactionTable.Call("remove", "d-none")
+		noTransactionTable.Call("add", "d-block")
+
+		return
+	}
+
+	transactionTable.Call("remove", "d-none")
+	transactionTable.Call("add", "d-block")
+
+	noTransactionTable.Call("remove", "d-block")
+	noTransactionTable.Call("add", "d-none")
+
+	transactions := document.Call(
+		"getElementById",
+		"transactions",
+	)
+
+	if !transactions.IsNull() && !transactions.IsUndefined() {
+		transactions.Set("innerHTML", "")
+	}
+
+	for _, transaction := range loadedTransactions {
+		renderTransaction("transactions", transaction)
+	}
+
+	summary := document.Call("getElementById", "history-summary")
+	if !summary.IsNull() && !summary.IsUndefined() {
+		summary.Set(
+			"innerHTML",
+			fmt.Sprintf("Showing %d of %d transactions.", len(loadedTransactions), transactionTotal),
+		)
+	}
+
+	moreButton := document.Call("getElementById", "history-more-btn")
+	if !moreButton.IsNull() && !moreButton.IsUndefined() {
+		if nextTransactionCursor == "" {
+			moreButton.Get("classList").Call("add", "d-none")
+		} else {
+			moreButton.Get("classList").Call("remove", "d-none")
+		}
+	}
+}
+
+func loadTransactions() {
+	page, hash, err := fetchTransactionPage("")
+	if err != nil {
+		showError("history-error", err.Error())
+		return
+	}
+
+	previousPageHash = hash
+	loadedTransactions = page.Transactions
+	transactionTotal = page.Total
+	nextTransactionCursor = page.NextCursor
+
+	renderTransactionTable()
+}
+
+func refreshTransactions() {
+	page, hash, err := fetchTransactionPage("")
+	if err != nil {
+		retryIfRateLimited(err.Error(), refreshTransactions)
+		return
+	}
+
+	if hash == previousPageHash {
+		return
+	}
+
+	previousPageHash = hash
+	loadedTransactions = page.Transactions
+	transactionTotal = page.Total
+	nextTransactionCursor = page.NextCursor
+
+	renderTransactionTable()
+}
+
+func transactionFiltersActive() bool {
+	for key, value := range transactionFilters("") {
+		if key != "cursor" && value != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func applyStreamTransactions(data string) {
+	var payload struct {
+		Transactions []Transaction `json:"transactions"`
+	}
+
+	if err := json.Unmarshal([]byte(data), &payload); err != nil ||
+		len(payload.Transactions) == 0 {
+		return
+	}
+
+	if transactionFiltersActive() {
+		refreshTransactions()
+		return
+	}
+
+	for _, transaction := range payload.Transactions {
+		updated := false
+		for index := range loadedTransactions {
+			if loadedTransactions[index].TransactionID == transaction.TransactionID {
+				loadedTransactions[index] = transaction
+				updated = true
+
+				break
+			}
+		}
+
+		if !updated {
+			loadedTransactions = append([]Transaction{transaction}, loadedTransactions...)
+			transactionTotal++
+		}
+	}
+
+	previousPageHash = ""
+	renderTransactionTable()
+}
+
+func loadMoreTransactions() {
+	if nextTransactionCursor == "" {
+		return
+	}
+
+	page, _, err := fetchTransactionPage(nextTransactionCursor)
+	if err != nil {
+		showError("history-error", err.Error())
+		return
+	}
+
+	loadedTransactions = append(loadedTransactions, page.Transactions...)
+	transactionTotal = page.Total
+	nextTransactionCursor = page.NextCursor
+
+	renderTransactionTable()
+}
+
+func downloadStatement() {
+	showLoading("statement")
+	defer hideLoading("statement")
+
+	status, contentType, body, err := sendPostBytes(
+		"/api/statement",
+		map[string]string{
+			"format": getInputValue("statement-format"),
+			"from":   getInputValue("history-from"),
+			"to":     getInputValue("history-to"),
+		},
+		authHeaders(),
+	)
+
+	if err != nil || status != 200 {
+		showError("history-error", "Internal error occured.")
+		return
+	}
+
+	if strings.HasPrefix(contentType, "application/json") {
+		var data map[string]string
+		if err := json.Unmarshal(body, &data); err != nil {
+			showError("history-error", "Internal error occured.")
+		} else {
+			showError("history-error", capitalizeFirst(data["message"]))
+		}
+
+		return
+	}
+
+	uint8Array := js.Global().Get("Uint8Array").New(len(body))
+	js.CopyBytesToJS(uint8Array, body)
+
+	blob := js.Global().Get("Blob").New(
+		[]interface{}{uint8Array},
+		map[string]interface{}{
+			"type": contentType,
+		},
+	)
+
+	url := js.Global().Get("URL").Call("createObjectURL", blob)
+	link := document.Call("createElement", "a")
+
+	link.Set("href", url)
+	link.Set("download", "ura-statement."+getInputValue("statement-format"))
+	link.Call("click")
+
+	js.Global().Get("URL").Call("revokeObjectURL", url)
+}
+
+func installTransactionHistory() {
+	buttons := map[string]func(){
+		"history-filter-btn":       loadTransactions,
+		"history-more-btn":         loadMoreTransactions,
+		"download-transaction-btn": downloadStatement,
+	}
+
+	for id, action := range buttons {
+		button := document.Call("getElementById", id)
+		if button.IsNull() || button.IsUndefined() {
+			continue
+		}
+
+		action := action
+		button.Call(
+			"addEventListener",
+			"click",
+			js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+				go action()
+				return nil
+			}),
+		)
+	}
+
+	go loadTransactions()
+}
@@ -0,0 +1,262 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"syscall/js"
+	"time"
+)
+
+type PaymentRequest struct {
+	TransactionID string `json:"transaction_id"`
+	Amount        Amount `json:"amount"`
+	Currency      string `json:"currency"`
+	Requester     string `json:"requester"`
+	Memo          string `json:"memo"`
+	CreatedAt     string `json:"created_at"`
+	ExpiresAt     string `json:"expires_at"`
+}
+
+type Notification struct {
+	ID            int    `json:"id"`
+	Kind          string `json:"kind"`
+	TransactionID string `json:"transaction_id"`
+	Message       string `json:"message"`
+	CreatedAt     string `json:"created_at"`
+	Read          bool   `json:"read"`
+}
+
+var inboxCallback js.Func
+var previousInboxHash string
+var inboxIdempotencyKeys = map[string]string{}
+var inboxReloadTimer *time.Timer
+
+const inboxReloadDelay = 500 * time.Millisecond
+
+func authHeaders() map[string]interface{} {
+	return map[string]interface{}{
+		"X-Session-Token": getSessionKey("session_token"),
+		"X-Security-Code": getSessionKey("security_code"),
+	}
+}
+
+func renderPaymentInbox(requests []PaymentRequest) {
+	inbox := document.Call("getElementById", "payment-inbox")
+	if inbox.IsNull() || inbox.IsUndefined() {
+		return
+	}
+
+	if len(requests) == 0 {
+		inbox.Set("innerHTML", `<p class="text-secondary">No payment requests for you.</p>`)
+		return
+	}
+
+	htmlContents := `
+	<div class="border rounded p-2 mb-2">
+		<p class="mb-1"><b>%s</b> requested <b>%s</b></p>
+		<p class="text-secondary mb-2">%s</p>
+		<div class="row">
+			<div class="col-6">
+				<button class="btn btn-outline-primary w-100" data-action="accept" data-transaction-id="%s">Accept</button>
+			</div>
+			<div class="col-6">
+				<button class="btn btn-outline-danger w-100" data-action="decline" data-transaction-id="%s">Decline</button>
+			</div>
+		</div>
+	</div>
+	`
+
+	inbox.Set("innerHTML", "")
+	for _, request := range requests {
+		transactionID := html.EscapeString(request.TransactionID)
+		inbox.Call(
+			"insertAdjacentHTML",
+			"beforeend",
+			fmt.Sprintf(
+				htmlContents,
+				html.EscapeString(request.Requester),
+				html.EscapeString(formatCurrency(numberWithCommas(request.Amount), request.Currency)),
+				html.EscapeString(request.Memo),
+				transactionID,
+				transactionID,
+			),
+		)
+	}
+}
+
+func renderNotifications(notifications []Notification) {
+	list := document.Call("getElementById", "notifications")
+	if list.IsNull() || list.IsUndefined() {
+		return
+	}
+
+	if len(notifications) == 0 {
+		list.Set("innerHTML", `<p class="text-secondary">No notifications yet.</p>`)
+		return
+	}
+
+	list.Set("innerHTML", "")
+	for _, notification := range notifications {
+		formattedTimestamp := notification.CreatedAt
+		if parsedTime, err := time.Parse(time.RFC3339, notification.CreatedAt); err == nil {
+			formattedTimestamp = parsedTime.Format("02/01/2006 15:04:05 MST")
+		}
+
+		textClass := "text-white"
+		if notification.Read {
+			textClass = "text-secondary"
+		}
+
+		list.Call(
+			"insertAdjacentHTML",
+			"beforeend",
+			fmt.Sprintf(
+				`<p class="%s mb-1">%s <small class="text-secondary">%s</small></p>`,
+				textClass,
+				html.EscapeString(notification.Message),
+				formattedTimestamp,
+			),
+		)
+	}
+}
+
+func loadPaymentInbox() {
+	var inbox struct {
+		Requests []PaymentRequest `json:"requests"`
+	}
+
+	status, _, inboxContent := sendPost("/api/payment/inbox", map[string]string{}, authHeaders())
+	if message := decodeResponse(status, inboxContent, &inbox); message != "" {
+		if !retryIfRateLimited(message, scheduleInboxReload) {
+			showError("inbox-error", message)
+		}
+
+		return
+	}
+
+	var notifications struct {
+		Notifications []Notification `json:"notifications"`
+	}
+
+	status, _, notificationContent := sendPost("/api/notifications", map[string]string{}, authHeaders())
+	if message := decodeResponse(status, notificationContent, &notifications); message != "" {
+		if !retryIfRateLimited(message, scheduleInboxReload) {
+			showError("inbox-error", message)
+		}
+
+		return
+	}
+
+	hideError("inbox-error")
+
+	hash := toSHA512(inboxContent + notificationContent)
+	if hash == previousInboxHash {
+		return
+	}
+
+	previousInboxHash = hash
+	renderPaymentInbox(inbox.Requests)
+	renderNotifications(notifications.Notifications)
+}
+
+func respondPaymentRequest(action string, transactionID string) {
+	keyName := action + ":" + transactionID
+	if _, exists := inboxIdempotencyKeys[keyName]; !exists {
+		inboxIdempotencyKeys[keyName] = newIdempotencyKey()
+	}
+
+	headers := authHeaders()
+	headers["Idempotency-Key"] = inboxIdempotencyKeys[keyName]
+
+	status, _, content := sendPost(
+		"/api/payment/"+action,
+		map[string]string{
+			"transaction_id": transactionID,
+		},
+		headers,
+	)
+
+	var data map[string]interface{}
+	err := json.Unmarshal([]byte(content), &data)
+
+	if err != nil || status != 200 {
+		showError("inbox-error", "Internal error occured.")
+		return
+	}
+
+	delete(inboxIdempotencyKeys, keyName)
+	if value, exists := data["status"]; exists && value != "ok" {
+		message, _ := data["message"].(string)
+		showError("inbox-error", capitalizeFirst(message))
+
+		return
+	}
+
+	if action == "accept" {
+		showError("inbox-success", "Payment request paid.")
+	} else {
+		showError("inbox-success", "Payment request declined.")
+	}
+
+	loadPaymentInbox()
+	loadInitialInformation()
+}
+
+func installPaymentInbox() {
+	inbox := document.Call("getElementById", "payment-inbox")
+	markRead := document.Call("getElementById", "notifications-read-btn")
+
+	if inbox.IsNull() || inbox.IsUndefined() ||
+		markRead.IsNull() || markRead.IsUndefined() {
+		return
+	}
+
+	inboxCallback = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		target := args[0].Get("target")
+		action := target.Call("getAttribute", "data-action")
+		transactionID := target.Call("getAttribute", "data-transaction-id")
+
+		if action.IsNull() || transactionID.IsNull() {
+			return nil
+		}
+
+		target.Set("disabled", true)
+		go respondPaymentRequest(action.String(), transactionID.String())
+
+		return nil
+	})
+	inbox.Call("addEventListener", "click", inboxCallback)
+
+	markRead.Call(
+		"addEventListener",
+		"click",
+		js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			go func() {
+				status, _, content := sendPost("/api/notifications/read", map[string]string{}, authHeaders())
+				if message := decodeResponse(status, content, nil); message != "" {
+					showError("inbox-error", message)
+					return
+				}
+
+				loadPaymentInbox()
+			}()
+
+			return nil
+		}),
+	)
+
+	go loadPaymentInbox()
+}
+
+func scheduleInboxReload() {
+	if inboxReloadTimer == nil {
+		inboxReloadTimer = time.AfterFunc(inboxReloadDelay, loadPaymentInbox)
+		return
+	}
+
+	inboxReloadTimer.Reset(inboxReloadDelay)
+}
@@ -6,83 +6,113 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"html"
 	"sort"
-	"syscall/js"
+	"strings"
 	"time"
 )
 
 type Transaction struct {
-	Amount        float64 `json:"amount"`
-	Category      string  `json:"category"`
-	CreatedAt     string  `json:"created_at"`
-	TransactionID string  `json:"transaction_id"`
-	Processed     int     `json:"processed"`
+	Amount               Amount `json:"amount"`
+	Category             string `json:"category"`
+	CreatedAt            string `json:"created_at"`
+	TransactionID        string `json:"transaction_id"`
+	Processed            int    `json:"processed"`
+	RelatedTransactionID string `json:"related_transaction_id"`
+	Currency             string `json:"currency"`
+	CounterAmount        Amount `json:"counter_amount"`
+	CounterCurrency      string `json:"counter_currency"`
 }
 
 type User struct {
-	ID         int     `json:"id"`
-	Username   string  `json:"username"`
-	Email      string  `json:"email"`
-	Identifier string  `json:"identifier"`
-	BalanceUra float64 `json:"balance_ura"`
-	CreatedAt  string  `json:"created_at"`
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	Identifier   string `json:"identifier"`
+	BalanceUra   Amount `json:"balance_ura"`
+	AvailableUra Amount `json:"available_ura"`
+	CreatedAt    string `json:"created_at"`
 }
 
 type Response struct {
-	Transactions []Transaction `json:"transactions"`
-	User         User          `json:"user"`
+	User     User              `json:"user"`
+	Balances map[string]Amount `json:"balances"`
 }
 
 var previousHash string
-var downloadCallback js.Func
-
-func renderTransaction(id string, transaction Transaction) {
-	htmlContents := `
-	<tr>
-		<td class="p-2">%s</td>
-		<td class="p-2">%s</td>
-		<td class="p-2">%g</td>
-		<td class="p-2 desktop-only" alt="%s">%s</td>
-		<td class="p-2 desktop-only">%s</td>
-	</tr>
-	`
-
-	status := "✓"
-	if transaction.Processed != 1 {
-		status = "×"
+
+func renderWalletBalances(balances map[string]Amount) {
+	walletBalances := document.Call(
+		"getElementById",
+		"wallet-balances",
+	)
+
+	if walletBalances.IsNull() || walletBalances.IsUndefined() {
+		return
 	}
 
-	formattedTimestamp := transaction.CreatedAt
-	if parsedTime, err := time.Parse(time.RFC3339, transaction.CreatedAt); err == nil {
-		formattedTimestamp = parsedTime.Format("02/01/2006 15:04:05 MST")
+	currencies := make([]string, 0, len(balances))
+	for currency, balance := range balances {
+		if balance != 0 {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+
+	if len(currencies) == 0 {
+		walletBalances.Get("classList").Call("add", "d-none")
+		return
 	}
 
-	tbody := js.Global().Get("document").Call(
+	parts := make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		parts = append(parts, numberWithCommas(balances[currency])+" "+currency)
+	}
+
+	walletBalances.Set("innerHTML", html.EscapeString(strings.Join(parts, " · ")))
+	walletBalances.Get("classList").Call("remove", "d-none")
+}
+
+func renderAvailableBalance(user User) {
+	availableAmount := document.Call(
 		"getElementById",
-		id,
+		"available-amount",
 	)
-	if !tbody.IsNull() && !tbody.IsUndefined() {
-		tidDisplay := transaction.TransactionID
-		if len(tidDisplay) > 12 {
-			tidDisplay = tidDisplay[:12]
-		}
 
-		tbody.Call(
-			"insertAdjacentHTML",
-			"beforeend",
-			fmt.Sprintf(
-				htmlContents,
-				html.EscapeString(capitalizeFirst(transaction.Category)),
-				formattedTimestamp,
-				transaction.Amount,
-				html.EscapeString(transaction.TransactionID),
-				html.EscapeString(tidDisplay),
-				status,
-			),
+	if availableAmount.IsNull() || availableAmount.IsUndefined() {
+		return
+	}
+
+	if user.AvailableUra == user.BalanceUra {
+		availableAmount.Get("classList").Call("add", "d-none")
+		return
+	}
+
+	availableAmount.Set(
+		"innerHTML",
+		html.EscapeString(
+			"Available: "+numberWithCommas(user.AvailableUra)+
+				" ("+numberWithCommas(user.BalanceUra-user.AvailableUra)+" on hold)",
+		),
+	)
+	availableAmount.Get("classList").Call("remove", "d-none")
+}
+
+func renderBalances(user User, balances map[string]Amount) {
+	creditAmount := document.Call(
+		"getElementById",
+		"credit-amount",
+	)
+
+	if !creditAmount.IsNull() && !creditAmount.IsUndefined() {
+		creditAmount.Set(
+			"innerHTML",
+			html.EscapeString(numberWithCommas(user.BalanceUra)),
 		)
 	}
+
+	renderAvailableBalance(user)
+	renderWalletBalances(balances)
 }
 
 func fetchInformation() (Response, string, error) {
@@ -131,10 +161,6 @@ func loadInitialInformation() {
 			"getElementById",
 			"card-identification",
 		)
-		creditAmount := document.Call(
-			"getElementById",
-			"credit-amount",
-		)
 
 		if !overviewUsername.IsNull() && !overviewUsername.IsUndefined() {
 			overviewUsername.Set(
@@ -157,93 +183,7 @@ func loadInitialInformation() {
 			)
 		}
 
-		if !creditAmount.IsNull() && !creditAmount.IsUndefined() {
-			creditAmount.Set(
-				"innerHTML",
-				html.EscapeString(numberWithCommas(data.User.BalanceUra)),
-			)
-		}
-
-		sort.Slice(data.Transactions, func(i, j int) bool {
-			t1, err := time.Parse(time.RFC3339, data.Transactions[i].CreatedAt)
-			if err != nil {
-				return false
-			}
-
-			t2, err := time.Parse(time.RFC3339, data.Transactions[j].CreatedAt)
-			if err != nil {
-				return false
-			}
-
-			return t1.After(t2)
-		})
-
-		transactionTable := document.Call(
-			"getElementById",
-			"transaction-table",
-		).Get("classList")
-
-		if transactionTable.IsNull() || transactionTable.IsUndefined() {
-			return
-		}
-
-		noTransactionTable := document.Call(
-			"getElementById",
-			"no-transactions",
-		).Get("classList")
-
-		if noTransactionTable.IsNull() || noTransactionTable.IsUndefined() {
-			return
-		}
-
-		if len(data.Transactions) != 0 {
-			transactionTable.Call("remove", "d-none")
-			transactionTable.Call("add", "d-block")
-
-			noTransactionTable.Call("remove", "d-block")
-			noTransactionTable.Call("add", "d-none")
-
-			transactions := document.Call(
-				"getElementById",
-				"transactions",
-			)
-
-			if !transactions.IsNull() && !transactions.IsUndefined() {
-				transactions.Set("innerHTML", "")
-			}
-
-			for _, transaction := range data.Transactions {
-				renderTransaction("transactions", transaction)
-			}
-
-			downloadBtn := document.Call(
-				"getElementById",
-				"download-transaction-btn",
-			)
-			if !downloadBtn.IsNull() && !downloadBtn.IsUndefined() {
-				if !downloadCallback.IsNull() && !downloadCallback.IsUndefined() {
-					downloadBtn.Call("removeEventListener", "click", downloadCallback)
-					downloadCallback.Release()
-				}
-
-				downloadCallback = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
-					generateTransactionPDF(data.Transactions)
-					return nil
-				})
-
-				downloadBtn.Call(
-					"addEventListener",
-					"click",
-					downloadCallback,
-				)
-			}
-		} else {
-			transactionTable.Call("remove", "d-block")
-			transactionTable.Call("add", "d-none")
-
-			noTransactionTable.Call("remove", "d-none")
-			noTransactionTable.Call("add", "d-block")
-		}
+		renderBalances(data.User, data.Balances)
 	}
 }
 
@@ -253,7 +193,13 @@ func updateInformation() {
 		defer ticker.Stop()
 
 		for range ticker.C {
+			if streamConnected {
+				continue
+			}
+
 			loadInitialInformation()
+			refreshTransactions()
+			loadPaymentInbox()
 		}
 	}()
 }
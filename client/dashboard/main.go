@@ -17,8 +17,12 @@ func main() {
 
 	fixTabAnimations()
 	installButtonActions()
+	installPaymentInbox()
+	installTransactionHistory()
+	installFeeQuotes()
 	showActualContent()
 
 	sessionValidationTicks()
+	connectEventStream()
 	<-done
 }
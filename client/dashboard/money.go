@@ -0,0 +1,61 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type Amount int64
+
+const amountScale = 100
+
+func (a Amount) String() string {
+	sign := ""
+	value := int64(a)
+
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	minor := strconv.FormatInt(value%amountScale, 10)
+	if len(minor) < 2 {
+		minor = "0" + minor
+	}
+
+	return sign + strconv.FormatInt(value/amountScale, 10) + "." + minor
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), "\"")
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+
+	intPart, fracPart, _ := strings.Cut(text, ".")
+	if len(fracPart) > 2 {
+		return errors.New("invalid amount")
+	}
+	fracPart += strings.Repeat("0", 2-len(fracPart))
+
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	minor, err := strconv.ParseInt(fracPart, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	value := units*amountScale + minor
+	if negative {
+		value = -value
+	}
+
+	*a = Amount(value)
+	return nil
+}
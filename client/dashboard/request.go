@@ -126,3 +126,137 @@ func sendPost(
 		return 0, "", "Timeout waiting for fetch response."
 	}
 }
+
+const (
+	errTooManyRequests  = "Too many requests"
+	rateLimitRetryDelay = 2 * time.Second
+)
+
+func retryIfRateLimited(message string, action func()) bool {
+	if message != errTooManyRequests {
+		return false
+	}
+
+	time.AfterFunc(rateLimitRetryDelay, action)
+	return true
+}
+
+func decodeResponse(status int, content string, target interface{}) string {
+	if status != 200 {
+		return "Internal error occured."
+	}
+
+	var result struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	}
+
+	if err := json.Unmarshal([]byte(content), &result); err != nil {
+		return "Internal error occured."
+	}
+
+	if result.Status != "ok" {
+		return capitalizeFirst(result.Message)
+	}
+
+	if target != nil {
+		if err := json.Unmarshal([]byte(content), target); err != nil {
+			return "Internal error occured."
+		}
+	}
+
+	return ""
+}
+
+func sendPostBytes(
+	urlStr string,
+	data map[string]string,
+	headers map[string]interface{},
+) (
+	status int,
+	contentType string,
+	body []byte,
+	err error,
+) {
+	jsonBody, err := json.Marshal(data)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	if _, exists := headers["Content-Type"]; !exists {
+		headers["Content-Type"] = "application/json"
+	}
+
+	opts := js.ValueOf(map[string]interface{}{
+		"method":  "POST",
+		"body":    string(jsonBody),
+		"headers": headers,
+	})
+
+	resCh := make(chan js.Value)
+	bufCh := make(chan js.Value)
+	errCh := make(chan error)
+
+	thenFunc := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resCh <- args[0]
+		return nil
+	})
+	bufferFunc := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		bufCh <- args[0]
+		return nil
+	})
+	catchFunc := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		errCh <- errors.New(args[0].String())
+		return nil
+	})
+
+	defer thenFunc.Release()
+	defer bufferFunc.Release()
+	defer catchFunc.Release()
+
+	js.Global().Call("fetch", urlStr, opts).Call(
+		"then",
+		thenFunc,
+	).Call(
+		"catch",
+		catchFunc,
+	)
+
+	select {
+	case res := <-resCh:
+		status = res.Get("status").Int()
+		contentType = res.Get("headers").Call(
+			"get",
+			"Content-Type",
+		).String()
+
+		res.Call("arrayBuffer").Call(
+			"then",
+			bufferFunc,
+		).Call(
+			"catch",
+			catchFunc,
+		)
+
+	case err := <-errCh:
+		return 0, "", nil, err
+
+	case <-time.After(30 * time.Second):
+		return 0, "", nil, errors.New("Timeout waiting for fetch response.")
+	}
+
+	select {
+	case buffer := <-bufCh:
+		array := js.Global().Get("Uint8Array").New(buffer)
+		body = make([]byte, array.Get("length").Int())
+		js.CopyBytesToGo(body, array)
+
+		return status, contentType, body, nil
+
+	case err := <-errCh:
+		return 0, "", nil, err
+
+	case <-time.After(30 * time.Second):
+		return 0, "", nil, errors.New("Timeout waiting for response body.")
+	}
+}
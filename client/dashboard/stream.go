@@ -0,0 +1,134 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"syscall/js"
+	"time"
+)
+
+const (
+	streamRetryDelay    = 5 * time.Second
+	streamMaxRetryDelay = time.Minute
+)
+
+type StreamBalance struct {
+	BalanceUra   Amount            `json:"balance_ura"`
+	AvailableUra Amount            `json:"available_ura"`
+	Balances     map[string]Amount `json:"balances"`
+}
+
+var (
+	streamConnected bool
+	streamRetry     = streamRetryDelay
+	eventSource     js.Value
+	streamCallbacks []js.Func
+)
+
+func closeEventStream() {
+	streamConnected = false
+	if !eventSource.IsUndefined() && !eventSource.IsNull() {
+		eventSource.Call("close")
+	}
+
+	for _, callback := range streamCallbacks {
+		callback.Release()
+	}
+
+	eventSource = js.Undefined()
+	streamCallbacks = nil
+}
+
+func scheduleStreamReconnect() {
+	delay := streamRetry
+	if streamRetry < streamMaxRetryDelay {
+		streamRetry *= 2
+	}
+
+	go func() {
+		time.Sleep(delay)
+		connectEventStream()
+	}()
+}
+
+func onStreamEvent(event string, handle func(data string)) {
+	callback := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		data := ""
+		if len(args) != 0 {
+			if value := args[0].Get("data"); value.Type() == js.TypeString {
+				data = value.String()
+			}
+		}
+
+		go handle(data)
+		return nil
+	})
+
+	streamCallbacks = append(streamCallbacks, callback)
+	eventSource.Call("addEventListener", event, callback)
+}
+
+func connectEventStream() {
+	constructor := js.Global().Get("EventSource")
+	if constructor.IsUndefined() || constructor.IsNull() {
+		return
+	}
+
+	go func() {
+		status, _, content := sendPost("/api/events/ticket", map[string]string{}, authHeaders())
+
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(content), &data); err != nil || status != 200 {
+			scheduleStreamReconnect()
+			return
+		}
+
+		ticket, _ := data["ticket"].(string)
+		if ticket == "" {
+			scheduleStreamReconnect()
+			return
+		}
+
+		closeEventStream()
+		eventSource = constructor.New("/api/events?ticket=" + url.QueryEscape(ticket))
+
+		onStreamEvent("open", func(string) {
+			streamConnected = true
+			streamRetry = streamRetryDelay
+		})
+
+		onStreamEvent("balance", func(data string) {
+			var balance StreamBalance
+			if err := json.Unmarshal([]byte(data), &balance); err != nil {
+				return
+			}
+
+			renderBalances(User{
+				BalanceUra:   balance.BalanceUra,
+				AvailableUra: balance.AvailableUra,
+			}, balance.Balances)
+		})
+
+		onStreamEvent("transactions", func(data string) {
+			applyStreamTransactions(data)
+			scheduleInboxReload()
+		})
+
+		onStreamEvent("notification", func(string) {
+			scheduleInboxReload()
+		})
+
+		onStreamEvent("session", func(string) {
+			closeEventStream()
+			periodicSessionValidation()
+		})
+
+		onStreamEvent("error", func(string) {
+			closeEventStream()
+			scheduleStreamReconnect()
+		})
+	}()
+}
@@ -4,19 +4,14 @@
 package main
 
 import (
-	"bytes"
+	"crypto/rand"
 	"crypto/sha512"
 	"encoding/hex"
-	"fmt"
 	"net/url"
-	"strconv"
 	"strings"
 	"syscall/js"
-	"time"
 	"unicode"
 	"unicode/utf8"
-
-	"github.com/jung-kurt/gofpdf"
 )
 
 func redirectTo(link string) {
@@ -41,8 +36,23 @@ func toSHA512(data string) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-func numberWithCommas(f float64) string {
-	s := fmt.Sprintf("%.2f", f)
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func numberWithCommas(amount Amount) string {
+	s := amount.String()
+	sign := ""
+
+	if strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = s[1:]
+	}
 	parts := strings.Split(s, ".")
 
 	intPart := parts[0]
@@ -59,171 +69,65 @@ func numberWithCommas(f float64) string {
 		result.WriteRune(c)
 	}
 
-	return result.String() + "." + decPart
+	return sign + result.String() + "." + decPart
 }
 
-func capitalizeFirst(s string) string {
-	if s == "" {
-		return s
+func formatCurrency(amount string, currency string) string {
+	if currency == "" || currency == "URA" {
+		return amount + " uro"
 	}
 
-	r, size := utf8.DecodeRuneInString(s)
-	return string(unicode.ToUpper(r)) + s[size:]
+	return amount + " " + currency
 }
 
-func generateTransactionPDF(transactions []Transaction) {
-	usernameObject := document.Call(
-		"getElementById",
-		"card-holder",
-	)
-
-	if usernameObject.IsNull() || usernameObject.IsUndefined() {
-		return
+func transactionAmount(transaction Transaction) string {
+	amount := numberWithCommas(transaction.Amount)
+	if transaction.Currency == "" || transaction.Currency == "URA" {
+		return amount
 	}
 
-	username := usernameObject.Get("innerHTML").String()
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pageCount := 1
-
-	pdf.SetMargins(10, 10, 10)
-	pdf.SetFooterFunc(func() {
-		pdf.SetY(-15)
-		pdf.SetFont("Helvetica", "I", 8)
-		now := time.Now().Format("02/01/2006 15:04:05 MST")
-		pdf.CellFormat(
-			0, 10,
-			"Generated on "+now+". Page "+strconv.Itoa(pageCount)+".",
-			"", 0,
-			"C", false,
-			0, "",
-		)
-	})
-	pdf.AddPage()
-
-	pageWidth, _ := pdf.GetPageSize()
-	left, _, right, _ := pdf.GetMargins()
-	availableWidth := pageWidth - left - right
-
-	colWidthID := availableWidth * 0.20
-	colWidthAmount := availableWidth * 0.15
-	colWidthCategory := availableWidth * 0.20
-	colWidthCreatedAt := availableWidth * 0.30
-	colWidthProcessed := availableWidth * 0.15
-
-	headers := []string{
-		"Transaction ID",
-		"Amount",
-		"Category",
-		"Timestamp",
-		"Processed",
-	}
+	return amount + " " + transaction.Currency
+}
 
-	colWidths := []float64{
-		colWidthID,
-		colWidthAmount,
-		colWidthCategory,
-		colWidthCreatedAt,
-		colWidthProcessed,
+func transactionStatus(processed int) string {
+	switch processed {
+	case 0:
+		return "Pending"
+	case 1:
+		return "✓"
+	case 2:
+		return "Rejected"
+	case 3:
+		return "Cancelled"
+	case 4:
+		return "Expired"
+	case 5:
+		return "Declined"
 	}
 
-	printTableHeader := func() {
-		pdf.SetFont("Helvetica", "B", 14)
-		pdf.CellFormat(0, 10, "Ura Transaction Slip", "", 1, "C", false, 0, "")
-
-		pdf.SetFont("Helvetica", "", 10)
-		pdf.CellFormat(
-			0, 4,
-			"This official Ura transaction slip was generated for "+username+".",
-			"", 1, "C",
-			false, 0, "",
-		)
-		pdf.Ln(5)
-
-		pdf.SetFont("Helvetica", "B", 10)
-		pdf.SetFillColor(200, 200, 200)
+	return "×"
+}
 
-		for i, header := range headers {
-			pdf.CellFormat(colWidths[i], 6, header, "1", 0, "C", true, 0, "")
-		}
+func transactionCategory(transaction Transaction) string {
+	category := capitalizeFirst(strings.ReplaceAll(transaction.Category, "_", " "))
 
-		pdf.Ln(-1)
-		pdf.SetFont("Helvetica", "", 12)
+	related := transaction.RelatedTransactionID
+	if related == "" {
+		return category
 	}
-	printTableHeader()
 
-	rowCount := 0
-	for _, t := range transactions {
-		if rowCount == 40 {
-			pdf.AddPage()
-			printTableHeader()
-
-			rowCount = 0
-			pageCount++
-		}
-
-		var fill bool
-		if rowCount%2 == 1 {
-			pdf.SetFillColor(240, 240, 240)
-			fill = true
-		} else {
-			pdf.SetFillColor(255, 255, 255)
-			fill = false
-		}
-
-		tid := t.TransactionID
-		if len(tid) > 12 {
-			tid = tid[:12]
-		}
-
-		var formattedCreatedAt string
-		if parsedTime, err := time.Parse(time.RFC3339, t.CreatedAt); err == nil {
-			formattedCreatedAt = parsedTime.Format("02/01/2006 15:04:05 MST")
-		} else {
-			formattedCreatedAt = t.CreatedAt
-		}
-
-		processedSymbol := "True"
-		if t.Processed == 0 {
-			processedSymbol = "False"
-		}
-
-		pdf.CellFormat(colWidths[0], 6, tid, "1", 0, "C", fill, 0, "")
-		pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%.2f", t.Amount), "1", 0, "C", fill, 0, "")
-		pdf.CellFormat(colWidths[2], 6, capitalizeFirst(t.Category), "1", 0, "C", fill, 0, "")
-		pdf.CellFormat(colWidths[3], 6, formattedCreatedAt, "1", 0, "C", fill, 0, "")
-		pdf.CellFormat(colWidths[4], 6, processedSymbol, "1", 0, "C", fill, 0, "")
-		pdf.Ln(-1)
-
-		rowCount++
+	if len(related) > 12 {
+		related = related[:12]
 	}
 
-	pdf.SetTitle("Ura Transaction Slip", false)
-	pdf.SetSubject("Official transaction slip", false)
-	pdf.SetAuthor(username, false)
-
-	var buf bytes.Buffer
-	if err := pdf.Output(&buf); err != nil {
-		return
-	}
+	return category + " of " + related
+}
 
-	jsUint8Array := js.Global().Get("Uint8Array")
-	if jsUint8Array.IsNull() || jsUint8Array.IsUndefined() {
-		return
+func capitalizeFirst(s string) string {
+	if s == "" {
+		return s
 	}
 
-	data := buf.Bytes()
-	uint8Array := jsUint8Array.New(len(data))
-	js.CopyBytesToJS(uint8Array, data)
-
-	blob := js.Global().Get("Blob").New(
-		[]interface{}{uint8Array},
-		map[string]interface{}{
-			"type": "application/pdf",
-		},
-	)
-
-	js.Global().Get("window").Call(
-		"open",
-		js.Global().Get("URL").Call("createObjectURL", blob),
-	)
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
 }
@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	UserCreated       = "user.created"
+	UserLogin         = "user.login"
+	UserLoginFailed   = "user.login_failed"
+	UserLogout        = "user.logout"
+	AccountClosing    = "account.closing"
+	AccountClosed     = "account.closed"
+	AccountStatus     = "account.status_changed"
+	RoleAssigned      = "account.role_assigned"
+	TierAssigned      = "account.tier_assigned"
+	BalanceAdjusted   = "balance.adjusted"
+	PaymentSent       = "payment.sent"
+	PaymentRequested  = "payment.requested"
+	PaymentRefunded   = "payment.refunded"
+	PaymentReversed   = "payment.reversed"
+	HoldAuthorized    = "hold.authorized"
+	HoldCaptured      = "hold.captured"
+	HoldVoided        = "hold.voided"
+	WithdrawRequested = "voucher.withdraw_requested"
+	CashInRequested   = "voucher.cashin_requested"
+	VoucherSettled    = "voucher.settled"
+	VoucherExpired    = "voucher.expired"
+	CurrencyConverted = "wallet.converted"
+	ExchangeRateSet   = "exchange.rate_set"
+	StandingOrderSet  = "standing_order.created"
+	StandingOrderRun  = "standing_order.executed"
+	WebhookCreated    = "webhook.created"
+	WebhookDeleted    = "webhook.deleted"
+	InterestSettled   = "interest.settled"
+)
+
+var Genesis = strings.Repeat("0", 64)
+
+type Event struct {
+	Action    string
+	ActorID   int64
+	SubjectID int64
+	IP        string
+	UserAgent string
+	Resource  string
+	Payload   []byte
+}
+
+type Entry struct {
+	ID          int64  `json:"id"`
+	CreatedAt   string `json:"created_at"`
+	Action      string `json:"action"`
+	ActorID     int64  `json:"actor_id"`
+	SubjectID   int64  `json:"subject_id"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"user_agent"`
+	Resource    string `json:"resource"`
+	PayloadHash string `json:"payload_hash"`
+}
+
+type Break struct {
+	ID     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+type Report struct {
+	Entries int64   `json:"entries"`
+	Head    string  `json:"head"`
+	Breaks  []Break `json:"breaks"`
+}
+
+func PayloadHash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func ChainHash(prev string, entry Entry) string {
+	data, _ := json.Marshal(entry)
+
+	sum := sha256.Sum256(append([]byte(prev+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+func nullable(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+
+	return id
+}
+
+func Record(tx *sql.Tx, event Event) error {
+	var lastID int64
+	prev := Genesis
+
+	err := tx.QueryRow(
+		"SELECT id, hash FROM audit_log ORDER BY id DESC LIMIT 1",
+	).Scan(&lastID, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if event.Payload == nil {
+		event.Payload = []byte("{}")
+	}
+
+	entry := Entry{
+		ID:          lastID + 1,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Action:      event.Action,
+		ActorID:     event.ActorID,
+		SubjectID:   event.SubjectID,
+		IP:          event.IP,
+		UserAgent:   event.UserAgent,
+		Resource:    event.Resource,
+		PayloadHash: PayloadHash(event.Payload),
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO audit_log
+		 (id, created_at, action, actor_id, subject_id, ip, user_agent, resource,
+		  payload, payload_hash, prev_hash, hash)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.CreatedAt, entry.Action, nullable(entry.ActorID), nullable(entry.SubjectID),
+		entry.IP, entry.UserAgent, entry.Resource, string(event.Payload),
+		entry.PayloadHash, prev, ChainHash(prev, entry),
+	)
+	return err
+}
+
+func Log(db *sql.DB, event Event) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = Record(tx, event); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func Verify(db *sql.DB) (Report, error) {
+	report := Report{Head: Genesis, Breaks: []Break{}}
+
+	rows, err := db.Query(
+		`SELECT id, created_at, action, COALESCE(actor_id, 0), COALESCE(subject_id, 0),
+		 ip, user_agent, resource, payload, payload_hash, prev_hash, hash
+		 FROM audit_log ORDER BY id`,
+	)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	var expectedID int64 = 1
+	for rows.Next() {
+		var entry Entry
+		var payload, prevHash, hash string
+
+		if err = rows.Scan(
+			&entry.ID, &entry.CreatedAt, &entry.Action, &entry.ActorID, &entry.SubjectID,
+			&entry.IP, &entry.UserAgent, &entry.Resource, &payload, &entry.PayloadHash,
+			&prevHash, &hash,
+		); err != nil {
+			return report, err
+		}
+
+		if entry.ID != expectedID {
+			report.Breaks = append(report.Breaks, Break{ID: entry.ID, Reason: "entries missing before this one"})
+		}
+
+		if prevHash != report.Head {
+			report.Breaks = append(report.Breaks, Break{ID: entry.ID, Reason: "previous hash does not match the chain"})
+		}
+
+		if PayloadHash([]byte(payload)) != entry.PayloadHash {
+			report.Breaks = append(report.Breaks, Break{ID: entry.ID, Reason: "payload does not match its hash"})
+		}
+
+		if ChainHash(prevHash, entry) != hash {
+			report.Breaks = append(report.Breaks, Break{ID: entry.ID, Reason: "entry does not match its hash"})
+		}
+
+		report.Entries++
+		report.Head = hash
+		expectedID = entry.ID + 1
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,136 @@
+package calendar
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+type Config struct {
+	TimeZone string   `json:"timezone"`
+	Weekends []string `json:"weekends"`
+	Holidays string   `json:"holidays"`
+}
+
+type Calendar struct {
+	location *time.Location
+	weekends map[time.Weekday]bool
+	holidays map[string]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func Default() *Calendar {
+	return &Calendar{
+		location: time.UTC,
+		weekends: map[time.Weekday]bool{
+			time.Saturday: true,
+			time.Sunday:   true,
+		},
+		holidays: map[string]bool{},
+	}
+}
+
+func Load(config Config) (*Calendar, error) {
+	calendar := Default()
+
+	if config.TimeZone != "" {
+		location, err := time.LoadLocation(config.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+
+		calendar.location = location
+	}
+
+	if config.Weekends != nil {
+		calendar.weekends = map[time.Weekday]bool{}
+		for _, name := range config.Weekends {
+			weekday, exists := weekdays[strings.ToLower(name)]
+			if !exists {
+				return nil, errors.New("unknown weekday: " + name)
+			}
+
+			calendar.weekends[weekday] = true
+		}
+
+		if len(calendar.weekends) == len(weekdays) {
+			return nil, errors.New("calendar has no business days")
+		}
+	}
+
+	if config.Holidays != "" {
+		holidays, err := loadHolidays(config.Holidays)
+		if err != nil {
+			return nil, err
+		}
+
+		calendar.holidays = holidays
+	}
+
+	return calendar, nil
+}
+
+func loadHolidays(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	holidays := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		date := strings.Fields(text)[0]
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid holiday date %q", path, line, date)
+		}
+
+		holidays[date] = true
+	}
+
+	return holidays, scanner.Err()
+}
+
+func (c *Calendar) Location() *time.Location {
+	return c.location
+}
+
+func (c *Calendar) IsBusinessDay(t time.Time) bool {
+	local := t.In(c.location)
+	if c.weekends[local.Weekday()] {
+		return false
+	}
+
+	return !c.holidays[local.Format("2006-01-02")]
+}
+
+func (c *Calendar) SubtractBusinessDays(t time.Time, n int) time.Time {
+	local := t.In(c.location)
+	for i := 0; i < n; i++ {
+		local = local.AddDate(0, 0, -1)
+		for !c.IsBusinessDay(local) {
+			local = local.AddDate(0, 0, -1)
+		}
+	}
+
+	return local.UTC()
+}
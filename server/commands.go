@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/db"
+	"github.com/nthnn/ura/handler"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+)
+
+func commandRole(args []string) int {
+	if len(args) != 2 {
+		logger.Error("Usage: ura role <username> <customer|agent|support|admin|auditor>")
+		return 1
+	}
+
+	if err := handler.AssignRole(database, args[0], args[1]); err != nil {
+		logger.Error("Error assigning role: %s", err.Error())
+		return 1
+	}
+
+	logger.Info("Assigned role %s to %s.", args[1], args[0])
+	return 0
+}
+
+func commandTier(args []string) int {
+	if len(args) != 2 {
+		logger.Error("Usage: ura tier <username> <tier>")
+		return 1
+	}
+
+	if err := handler.AssignTier(database, args[0], args[1]); err != nil {
+		logger.Error("Error assigning tier: %s", err.Error())
+		return 1
+	}
+
+	logger.Info("Assigned tier %s to %s.", args[1], args[0])
+	return 0
+}
+
+func commandAccountStatus(args []string) int {
+	if len(args) != 2 && len(args) != 3 {
+		logger.Error("Usage: ura account-status <username> <active|frozen> [reason]")
+		return 1
+	}
+
+	reason := ""
+	if len(args) == 3 {
+		reason = args[2]
+	}
+
+	if err := handler.SetAccountStatus(database, args[0], args[1], reason); err != nil {
+		logger.Error("Error changing account status: %s", err.Error())
+		return 1
+	}
+
+	logger.Info("Set account %s to %s.", args[0], args[1])
+	return 0
+}
+
+func commandLedgerVerify(args []string) int {
+	mismatches, err := ledger.Verify(database)
+	if err != nil {
+		logger.Error("Ledger verification failed: %s", err.Error())
+		return 1
+	}
+
+	for _, mismatch := range mismatches {
+		logger.Error(
+			"User %d %s balance is %s but postings total %s.",
+			mismatch.UserID,
+			mismatch.Currency,
+			mismatch.Cached.String(),
+			mismatch.Posted.String(),
+		)
+	}
+
+	if len(mismatches) != 0 {
+		return 1
+	}
+
+	logger.Info("Ledger verified, all balances match their postings.")
+	return 0
+}
+
+func commandAuditVerify(args []string) int {
+	report, err := audit.Verify(database)
+	if err != nil {
+		logger.Error("Audit log verification failed: %s", err.Error())
+		return 1
+	}
+
+	for _, broken := range report.Breaks {
+		logger.Error("Audit entry %d: %s.", broken.ID, broken.Reason)
+	}
+
+	if len(report.Breaks) != 0 {
+		return 1
+	}
+
+	logger.Info("Audit log verified, %d entries chain to %s.", report.Entries, report.Head)
+	return 0
+}
+
+func commandInterestReplay(args []string) int {
+	if len(args) != 2 && len(args) != 3 {
+		logger.Error("Usage: ura interest-replay <from> <to> [username]")
+		return 1
+	}
+
+	username := ""
+	if len(args) == 3 {
+		username = args[2]
+	}
+
+	summary, err := handler.ReplayInterest(database, args[0], args[1], username)
+	if err != nil {
+		logger.Error("Interest replay failed: %s", err.Error())
+		return 1
+	}
+
+	logger.Info(
+		"Replayed interest from %s to %s: %d accrual(s) changed, %d period(s) settled, %d adjusted.",
+		args[0], args[1], summary.Accrued, summary.Settled, summary.Adjusted,
+	)
+	return 0
+}
+
+func runCommand(args []string) int {
+	commands := map[string]func([]string) int{
+		"role":            commandRole,
+		"tier":            commandTier,
+		"account-status":  commandAccountStatus,
+		"ledger-verify":   commandLedgerVerify,
+		"audit-verify":    commandAuditVerify,
+		"interest-replay": commandInterestReplay,
+	}
+
+	command, exists := commands[args[0]]
+	if !exists {
+		logger.Error("Unknown command: %s", args[0])
+		return 1
+	}
+
+	var err error
+	database, err = db.Initialize(config.Database)
+	if err != nil {
+		logger.Error("Failed to initialize database: %s", err.Error())
+		return 1
+	}
+	defer database.Close()
+
+	return command(args[1:])
+}
@@ -1,13 +1,35 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var migrations = []func(*sql.Tx) error{
+	migrateVoucherSettlement,
+	migrateMoneyToMinorUnits,
+	migrateOpeningBalances,
+	migratePaymentRequestLifecycle,
+	migrateTargetedPaymentRequests,
+	migrateTransactionRelations,
+	migrateUserTiers,
+	migrateMultiCurrency,
+	migrateVoucherHolds,
+	migrateAccountLifecycle,
+	migrateIdempotentResponses,
+	migratePaymentRequestExpiry,
+	migrateTransactionVersions,
+}
+
 func Initialize(filePath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", filePath+"?_foreign_keys=on")
+	db, err := sql.Open(
+		"sqlite3",
+		filePath+"?_foreign_keys=on&_txlock=immediate&_busy_timeout=5000",
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -20,8 +42,10 @@ func Initialize(filePath string) (*sql.DB, error) {
             password TEXT NOT NULL,
             identifier TEXT NOT NULL,
             security_code TEXT NOT NULL,
-            balance_ura REAL DEFAULT 0,
-            created_at TEXT
+            balance_ura INTEGER DEFAULT 0,
+            role TEXT NOT NULL DEFAULT 'customer',
+            created_at TEXT,
+            tier TEXT
         );`,
 		`CREATE TABLE IF NOT EXISTS sessions (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -35,10 +59,282 @@ func Initialize(filePath string) (*sql.DB, error) {
             transaction_id TEXT,
             user_id INTEGER,
             category TEXT,
-            amount REAL,
+            amount INTEGER,
+            created_at TEXT,
+            processed INTEGER DEFAULT 1,
+            settled_by INTEGER,
+            settled_at TEXT,
+            memo TEXT,
+            expires_at TEXT,
+            target_user_id INTEGER,
+            related_transaction_id TEXT,
+            currency TEXT NOT NULL DEFAULT 'URA',
+            base_amount INTEGER,
+            fx_rate TEXT,
+            fx_spread_bps INTEGER,
+            counter_amount INTEGER,
+            counter_currency TEXT,
+            version INTEGER NOT NULL DEFAULT 0
+        );`,
+		`CREATE TABLE IF NOT EXISTS accounts (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            code TEXT NOT NULL UNIQUE,
+            user_id INTEGER,
+            created_at TEXT,
+            currency TEXT NOT NULL DEFAULT 'URA',
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS journal_entries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            transaction_id TEXT,
+            description TEXT NOT NULL,
+            created_at TEXT
+        );`,
+		`CREATE TABLE IF NOT EXISTS postings (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            entry_id INTEGER NOT NULL,
+            account_id INTEGER NOT NULL,
+            amount INTEGER NOT NULL,
+            FOREIGN KEY(entry_id) REFERENCES journal_entries(id),
+            FOREIGN KEY(account_id) REFERENCES accounts(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_postings_account ON postings(account_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_transactions_user_created ON transactions(user_id, created_at, id);`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            scope TEXT NOT NULL,
+            idempotency_key TEXT NOT NULL,
+            request_hash TEXT NOT NULL,
+            response TEXT,
+            created_at TEXT,
+            UNIQUE(scope, idempotency_key)
+        );`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL,
+            transaction_id TEXT,
+            message TEXT NOT NULL,
             created_at TEXT,
-            processed INTEGER DEFAULT 1
+            read_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications(user_id);`,
+		`CREATE TABLE IF NOT EXISTS wallet_balances (
+            user_id INTEGER NOT NULL,
+            currency TEXT NOT NULL,
+            balance INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY(user_id, currency),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS exchange_rates (
+            currency TEXT PRIMARY KEY,
+            rate TEXT NOT NULL,
+            spread_bps INTEGER NOT NULL DEFAULT 0,
+            updated_by INTEGER,
+            updated_at TEXT
+        );`,
+		`CREATE TABLE IF NOT EXISTS standing_orders (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            order_id TEXT NOT NULL UNIQUE,
+            user_id INTEGER NOT NULL,
+            recipient_id INTEGER NOT NULL,
+            amount INTEGER NOT NULL,
+            memo TEXT,
+            recurrence TEXT NOT NULL,
+            start_at TEXT NOT NULL,
+            end_at TEXT,
+            next_run_at TEXT NOT NULL,
+            run_count INTEGER NOT NULL DEFAULT 0,
+            attempts INTEGER NOT NULL DEFAULT 0,
+            status TEXT NOT NULL DEFAULT 'active',
+            created_at TEXT,
+            updated_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(recipient_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_standing_orders_due ON standing_orders(status, next_run_at);`,
+		`CREATE TABLE IF NOT EXISTS standing_order_executions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            order_id INTEGER NOT NULL,
+            transaction_id TEXT,
+            scheduled_for TEXT,
+            executed_at TEXT,
+            status TEXT NOT NULL,
+            error TEXT,
+            FOREIGN KEY(order_id) REFERENCES standing_orders(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS interest_accruals (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            product TEXT NOT NULL,
+            currency TEXT NOT NULL,
+            day TEXT NOT NULL,
+            balance INTEGER NOT NULL,
+            rate TEXT NOT NULL,
+            accrued_micros INTEGER NOT NULL,
+            created_at TEXT,
+            updated_at TEXT,
+            UNIQUE(user_id, product, day),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS interest_postings (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            product TEXT NOT NULL,
+            currency TEXT NOT NULL,
+            period TEXT NOT NULL,
+            transaction_id TEXT,
+            amount INTEGER NOT NULL,
+            created_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_interest_postings_period ON interest_postings(user_id, product, period);`,
+		`CREATE TABLE IF NOT EXISTS holds (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hold_id TEXT NOT NULL UNIQUE,
+            kind TEXT NOT NULL DEFAULT 'payment',
+            user_id INTEGER NOT NULL,
+            recipient_id INTEGER,
+            amount INTEGER NOT NULL,
+            captured INTEGER NOT NULL DEFAULT 0,
+            currency TEXT NOT NULL DEFAULT 'URA',
+            memo TEXT,
+            status TEXT NOT NULL DEFAULT 'authorized',
+            transaction_id TEXT,
+            expires_at TEXT NOT NULL,
+            created_at TEXT,
+            updated_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(recipient_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_holds_user_status ON holds(user_id, currency, status, expires_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_holds_status_expiry ON holds(status, expires_at);`,
+		`CREATE TABLE IF NOT EXISTS merchants (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL UNIQUE,
+            name TEXT NOT NULL,
+            next_reference INTEGER NOT NULL DEFAULT 1,
+            created_at TEXT,
+            updated_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS invoices (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            invoice_id TEXT NOT NULL UNIQUE,
+            merchant_id INTEGER NOT NULL,
+            customer_id INTEGER,
+            reference TEXT NOT NULL,
+            currency TEXT NOT NULL DEFAULT 'URA',
+            subtotal INTEGER NOT NULL,
+            tax INTEGER NOT NULL,
+            total INTEGER NOT NULL,
+            memo TEXT,
+            due_date TEXT NOT NULL,
+            created_at TEXT,
+            UNIQUE(merchant_id, reference),
+            FOREIGN KEY(merchant_id) REFERENCES merchants(id),
+            FOREIGN KEY(customer_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_invoices_merchant ON invoices(merchant_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS invoice_items (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            invoice_id INTEGER NOT NULL,
+            position INTEGER NOT NULL,
+            description TEXT NOT NULL,
+            quantity INTEGER NOT NULL,
+            unit_price INTEGER NOT NULL,
+            tax_rate TEXT NOT NULL,
+            amount INTEGER NOT NULL,
+            tax INTEGER NOT NULL,
+            FOREIGN KEY(invoice_id) REFERENCES invoices(id)
         );`,
+		`CREATE INDEX IF NOT EXISTS idx_invoice_items_invoice ON invoice_items(invoice_id, position);`,
+		`CREATE TABLE IF NOT EXISTS webhook_endpoints (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            endpoint_id TEXT NOT NULL UNIQUE,
+            user_id INTEGER NOT NULL,
+            url TEXT NOT NULL,
+            secret TEXT NOT NULL,
+            events TEXT NOT NULL DEFAULT '*',
+            active INTEGER NOT NULL DEFAULT 1,
+            created_at TEXT,
+            updated_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_endpoints_user ON webhook_endpoints(user_id, active);`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            delivery_id TEXT NOT NULL UNIQUE,
+            event_id TEXT NOT NULL,
+            endpoint_id INTEGER NOT NULL,
+            event TEXT NOT NULL,
+            payload TEXT NOT NULL,
+            status TEXT NOT NULL DEFAULT 'pending',
+            attempts INTEGER NOT NULL DEFAULT 0,
+            next_attempt_at TEXT,
+            response_code INTEGER,
+            last_error TEXT,
+            created_at TEXT,
+            delivered_at TEXT,
+            FOREIGN KEY(endpoint_id) REFERENCES webhook_endpoints(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint ON webhook_deliveries(endpoint_id, id);`,
+		`CREATE TABLE IF NOT EXISTS webhook_attempts (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            delivery_id INTEGER NOT NULL,
+            attempt INTEGER NOT NULL,
+            response_code INTEGER,
+            error TEXT,
+            duration_ms INTEGER NOT NULL,
+            attempted_at TEXT,
+            FOREIGN KEY(delivery_id) REFERENCES webhook_deliveries(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS account_status_changes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            from_status TEXT NOT NULL,
+            to_status TEXT NOT NULL,
+            reason TEXT,
+            changed_by INTEGER,
+            created_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(changed_by) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_account_status_changes_user ON account_status_changes(user_id, id);`,
+		`CREATE TABLE IF NOT EXISTS balance_adjustments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            transaction_id TEXT NOT NULL UNIQUE,
+            user_id INTEGER NOT NULL,
+            admin_id INTEGER NOT NULL,
+            currency TEXT NOT NULL,
+            amount INTEGER NOT NULL,
+            reason TEXT NOT NULL,
+            created_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(admin_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS audit_log (
+            id INTEGER PRIMARY KEY,
+            created_at TEXT NOT NULL,
+            action TEXT NOT NULL,
+            actor_id INTEGER,
+            subject_id INTEGER,
+            ip TEXT NOT NULL,
+            user_agent TEXT NOT NULL,
+            resource TEXT NOT NULL,
+            payload TEXT NOT NULL,
+            payload_hash TEXT NOT NULL,
+            prev_hash TEXT NOT NULL,
+            hash TEXT NOT NULL UNIQUE
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(actor_id, id);`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_subject ON audit_log(subject_id, id);`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+         BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+         BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;`,
 	}
 
 	for _, query := range queries {
@@ -49,6 +345,10 @@ func Initialize(filePath string) (*sql.DB, error) {
 		}
 	}
 
+	if err = migrate(db); err != nil {
+		return nil, err
+	}
+
 	db.SetMaxOpenConns(10)
 	if err = db.Ping(); err != nil {
 		return nil, err
@@ -56,3 +356,385 @@ func Initialize(filePath string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+func migrate(db *sql.DB) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var version int
+	if err = conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		if err = migrations[i](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if _, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func columnType(tx *sql.Tx, table, column string) (string, error) {
+	var columnType string
+	err := tx.QueryRow(
+		"SELECT type FROM pragma_table_info(?) WHERE name = ?",
+		table, column,
+	).Scan(&columnType)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return columnType, err
+}
+
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	existing, err := columnType(tx, table, column)
+	if err != nil || existing != "" {
+		return err
+	}
+
+	_, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+func migrateVoucherSettlement(tx *sql.Tx) error {
+	if err := addColumn(tx, "users", "role", "TEXT NOT NULL DEFAULT 'customer'"); err != nil {
+		return err
+	}
+
+	if err := addColumn(tx, "transactions", "settled_by", "INTEGER"); err != nil {
+		return err
+	}
+
+	return addColumn(tx, "transactions", "settled_at", "TEXT")
+}
+
+func migrateMoneyToMinorUnits(tx *sql.Tx) error {
+	balanceType, err := columnType(tx, "users", "balance_ura")
+	if err != nil || balanceType != "REAL" {
+		return err
+	}
+
+	statements := []string{
+		`CREATE TABLE users_minor (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            username TEXT NOT NULL,
+            email TEXT NOT NULL,
+            password TEXT NOT NULL,
+            identifier TEXT NOT NULL,
+            security_code TEXT NOT NULL,
+            balance_ura INTEGER DEFAULT 0,
+            role TEXT NOT NULL DEFAULT 'customer',
+            created_at TEXT
+        );`,
+		`INSERT INTO users_minor
+            (id, username, email, password, identifier, security_code, balance_ura, role, created_at)
+         SELECT id, username, email, password, identifier, security_code,
+            CAST(ROUND(COALESCE(balance_ura, 0) * 100) AS INTEGER), role, created_at
+         FROM users;`,
+		`DROP TABLE users;`,
+		`ALTER TABLE users_minor RENAME TO users;`,
+		`CREATE TABLE transactions_minor (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            transaction_id TEXT,
+            user_id INTEGER,
+            category TEXT,
+            amount INTEGER,
+            created_at TEXT,
+            processed INTEGER DEFAULT 1,
+            settled_by INTEGER,
+            settled_at TEXT
+        );`,
+		`INSERT INTO transactions_minor
+            (id, transaction_id, user_id, category, amount, created_at, processed, settled_by, settled_at)
+         SELECT id, transaction_id, user_id, category,
+            CAST(ROUND(amount * 100) AS INTEGER), created_at, processed, settled_by, settled_at
+         FROM transactions;`,
+		`DROP TABLE transactions;`,
+		`ALTER TABLE transactions_minor RENAME TO transactions;`,
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateOpeningBalances(tx *sql.Tx) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := tx.Exec(
+		"INSERT OR IGNORE INTO accounts (code, created_at) VALUES ('bank:opening', ?)",
+		now,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`INSERT OR IGNORE INTO accounts (code, user_id, created_at)
+		 SELECT 'wallet:' || id, id, ? FROM users`,
+		now,
+	); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(
+		`SELECT u.id, u.balance_ura, a.id FROM users u
+		 JOIN accounts a ON a.code = 'wallet:' || u.id
+		 WHERE u.balance_ura != 0`,
+	)
+	if err != nil {
+		return err
+	}
+
+	type opening struct {
+		userID, balance, accountID int64
+	}
+
+	var openings []opening
+	for rows.Next() {
+		var o opening
+		if err = rows.Scan(&o.userID, &o.balance, &o.accountID); err != nil {
+			rows.Close()
+			return err
+		}
+
+		openings = append(openings, o)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, o := range openings {
+		res, err := tx.Exec(
+			"INSERT INTO journal_entries (description, created_at) VALUES ('opening', ?)",
+			now,
+		)
+		if err != nil {
+			return err
+		}
+
+		entryID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(
+			"INSERT INTO postings (entry_id, account_id, amount) VALUES (?, ?, ?)",
+			entryID, o.accountID, o.balance,
+		); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(
+			`INSERT INTO postings (entry_id, account_id, amount)
+			 SELECT ?, id, ? FROM accounts WHERE code = 'bank:opening'`,
+			entryID, -o.balance,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migratePaymentRequestLifecycle(tx *sql.Tx) error {
+	if err := addColumn(tx, "transactions", "memo", "TEXT"); err != nil {
+		return err
+	}
+
+	return addColumn(tx, "transactions", "expires_at", "TEXT")
+}
+
+func migrateTargetedPaymentRequests(tx *sql.Tx) error {
+	return addColumn(tx, "transactions", "target_user_id", "INTEGER")
+}
+
+func migrateTransactionRelations(tx *sql.Tx) error {
+	if err := addColumn(tx, "transactions", "related_transaction_id", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		"CREATE INDEX IF NOT EXISTS idx_transactions_related ON transactions(related_transaction_id)",
+	)
+	return err
+}
+
+func migrateUserTiers(tx *sql.Tx) error {
+	return addColumn(tx, "users", "tier", "TEXT")
+}
+
+func migrateMultiCurrency(tx *sql.Tx) error {
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"accounts", "currency", "TEXT NOT NULL DEFAULT 'URA'"},
+		{"transactions", "currency", "TEXT NOT NULL DEFAULT 'URA'"},
+		{"transactions", "base_amount", "INTEGER"},
+		{"transactions", "fx_rate", "TEXT"},
+		{"transactions", "fx_spread_bps", "INTEGER"},
+		{"transactions", "counter_amount", "INTEGER"},
+		{"transactions", "counter_currency", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateVoucherHolds(tx *sql.Tx) error {
+	var notNull int
+	err := tx.QueryRow(
+		`SELECT "notnull" FROM pragma_table_info('holds') WHERE name = 'recipient_id'`,
+	).Scan(&notNull)
+
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if notNull == 1 {
+		statements := []string{
+			`CREATE TABLE holds_optional (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            hold_id TEXT NOT NULL UNIQUE,
+            kind TEXT NOT NULL DEFAULT 'payment',
+            user_id INTEGER NOT NULL,
+            recipient_id INTEGER,
+            amount INTEGER NOT NULL,
+            captured INTEGER NOT NULL DEFAULT 0,
+            currency TEXT NOT NULL DEFAULT 'URA',
+            memo TEXT,
+            status TEXT NOT NULL DEFAULT 'authorized',
+            transaction_id TEXT,
+            expires_at TEXT NOT NULL,
+            created_at TEXT,
+            updated_at TEXT,
+            FOREIGN KEY(user_id) REFERENCES users(id),
+            FOREIGN KEY(recipient_id) REFERENCES users(id)
+        );`,
+			`INSERT INTO holds_optional
+            (id, hold_id, user_id, recipient_id, amount, captured, currency, memo, status,
+             transaction_id, expires_at, created_at, updated_at)
+         SELECT id, hold_id, user_id, recipient_id, amount, captured, currency, memo, status,
+            transaction_id, expires_at, created_at, updated_at
+         FROM holds;`,
+			`DROP TABLE holds;`,
+			`ALTER TABLE holds_optional RENAME TO holds;`,
+			`CREATE INDEX IF NOT EXISTS idx_holds_user_status ON holds(user_id, currency, status, expires_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_holds_status_expiry ON holds(status, expires_at);`,
+		}
+
+		for _, statement := range statements {
+			if _, err = tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = addColumn(tx, "holds", "kind", "TEXT NOT NULL DEFAULT 'payment'"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"CREATE INDEX IF NOT EXISTS idx_holds_transaction ON holds(transaction_id)",
+	)
+	return err
+}
+
+func migrateAccountLifecycle(tx *sql.Tx) error {
+	columns := []struct {
+		column, definition string
+	}{
+		{"status", "TEXT NOT NULL DEFAULT 'active'"},
+		{"status_reason", "TEXT"},
+		{"status_changed_at", "TEXT"},
+		{"closed_at", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := addColumn(tx, "users", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateIdempotentResponses(tx *sql.Tx) error {
+	if err := addColumn(tx, "idempotency_keys", "response_status", "INTEGER NOT NULL DEFAULT 200"); err != nil {
+		return err
+	}
+
+	return addColumn(
+		tx, "idempotency_keys", "response_headers",
+		`TEXT NOT NULL DEFAULT '{"Content-Type":["application/json"]}'`,
+	)
+}
+
+func migratePaymentRequestExpiry(tx *sql.Tx) error {
+	_, err := tx.Exec(
+		`UPDATE transactions SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at, '+7 days')
+		 WHERE category = 'payment_request' AND processed = 0 AND expires_at IS NULL`,
+	)
+	return err
+}
+
+func migrateTransactionVersions(tx *sql.Tx) error {
+	if err := addColumn(tx, "transactions", "version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	queries := []string{
+		"CREATE INDEX IF NOT EXISTS idx_transactions_version ON transactions(version)",
+		`CREATE TRIGGER IF NOT EXISTS transactions_processed_version
+		 AFTER UPDATE OF processed ON transactions
+		 WHEN NEW.processed IS NOT OLD.processed
+		 BEGIN
+		   UPDATE transactions SET version = (SELECT MAX(version) + 1 FROM transactions)
+		   WHERE id = NEW.id;
+		 END`,
+	}
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package fee
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/nthnn/ura/money"
+)
+
+const (
+	Payment  = "payment"
+	Withdraw = "withdraw"
+	CashIn   = "cashin"
+)
+
+var (
+	ErrUnknownKind    = errors.New("fee kind must be payment, withdraw or cashin")
+	ErrInvalidPercent = errors.New("fee percent must be between 0 and 100")
+	ErrNegativeAmount = errors.New("fee amounts cannot be negative")
+	ErrInvalidCap     = errors.New("fee maximum cannot be below its minimum")
+	ErrInvalidBands   = errors.New("fee bands must have ascending limits, only the last may be open")
+)
+
+type Band struct {
+	UpTo    money.Amount `json:"up_to"`
+	Flat    money.Amount `json:"flat"`
+	Percent string       `json:"percent"`
+
+	percent *big.Rat
+}
+
+type Rule struct {
+	Flat    money.Amount `json:"flat"`
+	Percent string       `json:"percent"`
+	Min     money.Amount `json:"min"`
+	Max     money.Amount `json:"max"`
+	Bands   []Band       `json:"bands"`
+
+	percent *big.Rat
+}
+
+type Config struct {
+	Rules map[string]Rule            `json:"rules"`
+	Tiers map[string]map[string]Rule `json:"tiers"`
+}
+
+type Schedule struct {
+	rules map[string]Rule
+	tiers map[string]map[string]Rule
+}
+
+func Default() *Schedule {
+	return &Schedule{
+		rules: map[string]Rule{},
+		tiers: map[string]map[string]Rule{},
+	}
+}
+
+func parsePercent(s string) (*big.Rat, error) {
+	if s == "" {
+		return new(big.Rat), nil
+	}
+
+	percent, ok := new(big.Rat).SetString(s)
+	if !ok || percent.Sign() < 0 || percent.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, ErrInvalidPercent
+	}
+
+	return percent.Quo(percent, big.NewRat(100, 1)), nil
+}
+
+func validKind(kind string) bool {
+	return kind == Payment || kind == Withdraw || kind == CashIn
+}
+
+func loadRule(rule Rule) (Rule, error) {
+	var err error
+	if rule.percent, err = parsePercent(rule.Percent); err != nil {
+		return rule, err
+	}
+
+	if rule.Flat < 0 || rule.Min < 0 || rule.Max < 0 {
+		return rule, ErrNegativeAmount
+	}
+
+	if rule.Max != 0 && rule.Max < rule.Min {
+		return rule, ErrInvalidCap
+	}
+
+	bands := make([]Band, len(rule.Bands))
+	for i, band := range rule.Bands {
+		if band.percent, err = parsePercent(band.Percent); err != nil {
+			return rule, err
+		}
+
+		if band.Flat < 0 || band.UpTo < 0 {
+			return rule, ErrNegativeAmount
+		}
+
+		if band.UpTo == 0 && i != len(rule.Bands)-1 {
+			return rule, ErrInvalidBands
+		}
+
+		if i > 0 && band.UpTo != 0 && band.UpTo <= bands[i-1].UpTo {
+			return rule, ErrInvalidBands
+		}
+
+		bands[i] = band
+	}
+	rule.Bands = bands
+
+	return rule, nil
+}
+
+func loadRules(rules map[string]Rule) (map[string]Rule, error) {
+	loaded := map[string]Rule{}
+	for kind, rule := range rules {
+		if !validKind(kind) {
+			return nil, fmt.Errorf("fee rule %q: %w", kind, ErrUnknownKind)
+		}
+
+		rule, err := loadRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("fee rule %s: %w", kind, err)
+		}
+
+		loaded[kind] = rule
+	}
+
+	return loaded, nil
+}
+
+func Load(config Config) (*Schedule, error) {
+	schedule := Default()
+
+	rules, err := loadRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	schedule.rules = rules
+
+	for tier, overrides := range config.Tiers {
+		rules, err := loadRules(overrides)
+		if err != nil {
+			return nil, fmt.Errorf("tier %s: %w", tier, err)
+		}
+
+		schedule.tiers[tier] = rules
+	}
+
+	return schedule, nil
+}
+
+func (s *Schedule) Tiers() []string {
+	tiers := make([]string, 0, len(s.tiers))
+	for tier := range s.tiers {
+		tiers = append(tiers, tier)
+	}
+
+	return tiers
+}
+
+func (s *Schedule) Rule(kind, tier string) (Rule, bool) {
+	if rule, exists := s.tiers[tier][kind]; exists {
+		return rule, true
+	}
+
+	rule, exists := s.rules[kind]
+	return rule, exists
+}
+
+func percentOf(amount money.Amount, percent *big.Rat) money.Amount {
+	if percent == nil || percent.Sign() == 0 {
+		return 0
+	}
+
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(amount)), percent)
+	value.Add(value, big.NewRat(1, 2))
+
+	return money.Amount(new(big.Int).Quo(value.Num(), value.Denom()).Int64())
+}
+
+func (r Rule) Charge(amount money.Amount) money.Amount {
+	flat, percent := r.Flat, r.percent
+	for _, band := range r.Bands {
+		if band.UpTo == 0 || amount <= band.UpTo {
+			flat, percent = band.Flat, band.percent
+			break
+		}
+	}
+
+	charge := flat + percentOf(amount, percent)
+	if charge < r.Min {
+		charge = r.Min
+	}
+
+	if r.Max != 0 && charge > r.Max {
+		charge = r.Max
+	}
+
+	return charge
+}
+
+func (s *Schedule) Quote(kind, tier string, amount money.Amount) money.Amount {
+	rule, exists := s.Rule(kind, tier)
+	if !exists || amount <= 0 {
+		return 0
+	}
+
+	return rule.Charge(amount)
+}
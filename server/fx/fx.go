@@ -0,0 +1,152 @@
+package fx
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/nthnn/ura/money"
+)
+
+const (
+	RateScale = 100000000
+	MaxSpread = 1000
+)
+
+type Rate int64
+
+type Entry struct {
+	Currency  string `json:"currency"`
+	Rate      Rate   `json:"rate"`
+	SpreadBps int    `json:"spread_bps"`
+}
+
+type Quote struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Rate      Rate         `json:"rate"`
+	SpreadBps int          `json:"spread_bps"`
+	Amount    money.Amount `json:"amount"`
+	Converted money.Amount `json:"converted"`
+}
+
+var (
+	ErrInvalidCurrency = errors.New("currency must be a three-letter uppercase code")
+	ErrInvalidRate     = errors.New("rate must be a positive decimal with at most 8 decimals")
+	ErrInvalidSpread   = errors.New("spread must be between 0 and 1000 basis points")
+)
+
+func ValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+
+	for i := 0; i < len(currency); i++ {
+		if currency[i] < 'A' || currency[i] > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ParseRate(s string) (Rate, error) {
+	value, ok := new(big.Rat).SetString(s)
+	if !ok || value.Sign() <= 0 || strings.ContainsAny(s, "eE/") {
+		return 0, ErrInvalidRate
+	}
+
+	if _, frac, _ := strings.Cut(s, "."); len(frac) > 8 {
+		return 0, ErrInvalidRate
+	}
+
+	scaled := new(big.Rat).Mul(value, big.NewRat(RateScale, 1))
+	if !scaled.IsInt() || !scaled.Num().IsInt64() {
+		return 0, ErrInvalidRate
+	}
+
+	return Rate(scaled.Num().Int64()), nil
+}
+
+func (r Rate) String() string {
+	return new(big.Rat).SetFrac64(int64(r), RateScale).FloatString(8)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	rate, err := ParseRate(strings.Trim(string(data), "\""))
+	if err != nil {
+		return err
+	}
+
+	*r = rate
+	return nil
+}
+
+func (e Entry) Validate() error {
+	if !ValidCurrency(e.Currency) || e.Currency == money.BaseCurrency {
+		return ErrInvalidCurrency
+	}
+
+	if e.Rate <= 0 {
+		return ErrInvalidRate
+	}
+
+	if e.SpreadBps < 0 || e.SpreadBps > MaxSpread {
+		return ErrInvalidSpread
+	}
+
+	return nil
+}
+
+func Cross(from, to Entry, amount money.Amount) Quote {
+	spread := from.SpreadBps + to.SpreadBps
+
+	applied := new(big.Int).Mul(big.NewInt(int64(to.Rate)), big.NewInt(RateScale))
+	applied.Mul(applied, big.NewInt(int64(10000-spread)))
+	applied.Quo(applied, new(big.Int).Mul(big.NewInt(int64(from.Rate)), big.NewInt(10000)))
+
+	converted := new(big.Int).Mul(big.NewInt(int64(amount)), applied)
+	converted.Quo(converted, big.NewInt(RateScale))
+
+	return Quote{
+		From:      from.Currency,
+		To:        to.Currency,
+		Rate:      Rate(applied.Int64()),
+		SpreadBps: spread,
+		Amount:    amount,
+		Converted: money.Amount(converted.Int64()),
+	}
+}
+
+func Base() Entry {
+	return Entry{
+		Currency: money.BaseCurrency,
+		Rate:     RateScale,
+	}
+}
+
+func LoadFile(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if err = entry.Validate(); err != nil {
+			return nil, errors.New(path + ": " + entry.Currency + ": " + err.Error())
+		}
+	}
+
+	return entries, nil
+}
@@ -2,4 +2,7 @@ module github.com/nthnn/ura
 
 go 1.23.3
 
-require github.com/mattn/go-sqlite3 v1.14.24
+require (
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/mattn/go-sqlite3 v1.14.24
+)
@@ -0,0 +1,635 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/rbac"
+	"github.com/nthnn/ura/util"
+)
+
+const (
+	accountActive  = "active"
+	accountFrozen  = "frozen"
+	accountClosing = "closing"
+	accountClosed  = "closed"
+)
+
+var (
+	errAccountFrozen           = "Account is frozen"
+	errAccountClosing          = "Account is being closed"
+	errAccountClosed           = "Account is closed"
+	errCounterpartyInactive    = "Counterparty account is not active"
+	errAccountHasPendingItems  = "Account has pending holds or vouchers"
+	errAccountHasBalance       = "Account balance must be zero or paid out before closing"
+	errForeignBalanceRemaining = "Convert foreign currency balances before requesting a payout"
+	errAccountNotClosing       = "Account is not being closed"
+	errInvalidAccountStatus    = "Invalid account status"
+	errInvalidStatusReason     = "Reason must be at most 140 characters"
+	errUserNotFound            = "User not found"
+	errCannotChangeOwnStatus   = "Cannot change own account status"
+)
+
+func accountStateError(status string) string {
+	switch status {
+	case accountFrozen:
+		return errAccountFrozen
+	case accountClosing:
+		return errAccountClosing
+	case accountClosed:
+		return errAccountClosed
+	}
+
+	return ""
+}
+
+func accountStatus(q queryer, userID int64) (string, error) {
+	var status string
+	err := q.QueryRow("SELECT status FROM users WHERE id = ?", userID).Scan(&status)
+
+	return status, err
+}
+
+func authenticateActive(db *sql.DB, r *http.Request, allowed ...string) (*User, string) {
+	user, authErr := authenticate(db, r)
+	if authErr != "" {
+		return nil, authErr
+	}
+
+	if user.Status == accountActive {
+		return user, ""
+	}
+
+	for _, status := range allowed {
+		if user.Status == status {
+			return user, ""
+		}
+	}
+
+	return nil, accountStateError(user.Status)
+}
+
+func setAccountStatus(tx *sql.Tx, r *http.Request, userID int64, status, reason string, changedBy interface{}) error {
+	previous, err := accountStatus(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var closedAt interface{}
+	if status == accountClosed {
+		closedAt = now
+	}
+
+	if _, err = tx.Exec(
+		`UPDATE users SET status = ?, status_reason = ?, status_changed_at = ?, closed_at = ?
+		 WHERE id = ?`,
+		status, reason, now, closedAt, userID,
+	); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		`INSERT INTO account_status_changes (user_id, from_status, to_status, reason, changed_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, previous, status, reason, changedBy, now,
+	); err != nil {
+		return err
+	}
+
+	action := audit.AccountStatus
+	switch status {
+	case accountClosing:
+		action = audit.AccountClosing
+	case accountClosed:
+		action = audit.AccountClosed
+	}
+
+	actorID, _ := changedBy.(int64)
+	return recordAudit(tx, r, action, actorID, userID, "", map[string]string{
+		"from":   previous,
+		"to":     status,
+		"reason": reason,
+	})
+}
+
+func closureBlocker(q queryer, userID int64) (string, error) {
+	var pending int
+	if err := q.QueryRow(
+		`SELECT
+		  (SELECT COUNT(*) FROM holds WHERE status = ?1 AND (user_id = ?2 OR recipient_id = ?2)) +
+		  (SELECT COUNT(*) FROM transactions
+		   WHERE user_id = ?2 AND category IN ('cashin', 'withdraw') AND processed = ?3)`,
+		holdAuthorized, userID, transactionPending,
+	).Scan(&pending); err != nil {
+		return "", err
+	}
+
+	if pending != 0 {
+		return errAccountHasPendingItems, nil
+	}
+
+	var funded int
+	if err := q.QueryRow(
+		`SELECT
+		  (SELECT COUNT(*) FROM users WHERE id = ?1 AND balance_ura <> 0) +
+		  (SELECT COUNT(*) FROM wallet_balances WHERE user_id = ?1 AND balance <> 0)`,
+		userID,
+	).Scan(&funded); err != nil {
+		return "", err
+	}
+
+	if funded != 0 {
+		return errAccountHasBalance, nil
+	}
+
+	return "", nil
+}
+
+func windDownAccount(tx *sql.Tx, userID int64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if _, err := tx.Exec(
+		`UPDATE transactions SET processed = ?
+		 WHERE user_id = ? AND category = 'payment_request' AND processed = ?`,
+		transactionCancelled, userID, transactionPending,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`UPDATE standing_orders SET status = ?, updated_at = ?
+		 WHERE user_id = ? AND status IN (?, ?)`,
+		standingOrderCancelled, now, userID, standingOrderActive, standingOrderPaused,
+	)
+	return err
+}
+
+func completeClosure(tx *sql.Tx, r *http.Request, userID int64, reason string, changedBy interface{}) error {
+	if err := setAccountStatus(tx, r, userID, accountClosed, reason, changedBy); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE webhook_deliveries SET status = ?, next_attempt_at = NULL, last_error = 'Account closed'
+		 WHERE status = ? AND endpoint_id IN (SELECT id FROM webhook_endpoints WHERE user_id = ?)`,
+		deliveryFailed, deliveryPending, userID,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		"UPDATE webhook_endpoints SET active = 0, updated_at = ? WHERE user_id = ? AND active = 1",
+		time.Now().UTC().Format(time.RFC3339), userID,
+	)
+	return err
+}
+
+func finalizeClosures(db *sql.DB) error {
+	rows, err := db.Query("SELECT id FROM users WHERE status = ? ORDER BY id", accountClosing)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		status, err := accountStatus(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		blocker, err := closureBlocker(tx, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if status != accountClosing || blocker != "" {
+			tx.Rollback()
+			continue
+		}
+
+		if err = completeClosure(tx, nil, id, "Balance settled", nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+
+		logger.Info("Account %d closed after settling its balance.", id)
+	}
+
+	return nil
+}
+
+func RunAccountClosures(db *sql.DB) func(time.Time) error {
+	return func(time.Time) error {
+		return finalizeClosures(db)
+	}
+}
+
+func payoutAmount(tx *sql.Tx, user *User, available money.Amount) (money.Amount, bool, string) {
+	low, high := money.Amount(0), available
+	for low < high {
+		mid := high - (high-low)/2
+
+		charge, quoteErr := quoteFee(tx, fee.Withdraw, user.Tier, money.BaseCurrency, mid)
+		if quoteErr != "" {
+			return 0, false, quoteErr
+		}
+
+		if mid+charge <= available {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if low <= 0 {
+		return available, true, ""
+	}
+
+	return low, false, ""
+}
+
+func accountClose(db *sql.DB, method string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r, accountClosing)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Payout bool `json:"payout"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if err = windDownAccount(tx, user.ID); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if user.Status == accountActive {
+			if err = setAccountStatus(tx, r, user.ID, accountClosing, "Requested by user", user.ID); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+		}
+
+		var payout interface{}
+		if req.Payout {
+			var foreign int
+			if err = tx.QueryRow(
+				"SELECT COUNT(*) FROM wallet_balances WHERE user_id = ? AND currency <> ? AND balance <> 0",
+				user.ID, money.BaseCurrency,
+			).Scan(&foreign); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			if foreign != 0 {
+				tx.Rollback()
+				util.WriteJSONError(w, errForeignBalanceRemaining)
+
+				return
+			}
+
+			available, err := availableBalance(tx, user.ID, money.BaseCurrency)
+			if err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			if available > 0 {
+				amount, waiveFee, payoutErr := payoutAmount(tx, user, available)
+				if payoutErr != "" {
+					tx.Rollback()
+					util.WriteJSONError(w, payoutErr)
+
+					return
+				}
+
+				voucher, voucherErr := createWithdrawVoucher(tx, user, amount, waiveFee)
+				if voucherErr != "" {
+					tx.Rollback()
+					util.WriteJSONError(w, voucherErr)
+
+					return
+				}
+
+				payout = map[string]interface{}{
+					"transaction_id": voucher.transactionID,
+					"amount":         voucher.amount,
+					"fee":            voucher.fee,
+					"expires_at":     voucher.expiresAt,
+				}
+			}
+		}
+
+		blocker, err := closureBlocker(tx, user.ID)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		status := accountClosing
+		if blocker == "" {
+			if err = completeClosure(tx, r, user.ID, "Requested by user", user.ID); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			status = accountClosed
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		response := map[string]interface{}{
+			"status":         "ok",
+			"account_status": status,
+		}
+
+		if blocker != "" {
+			response["pending"] = blocker
+		}
+
+		if payout != nil {
+			response["payout"] = payout
+		}
+
+		logger.Info("Account %d is now %s.", user.ID, status)
+		util.WriteJSON(w, response)
+	}
+}
+
+func AccountClose(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return accountClose(db, http.MethodPost)
+}
+
+func UserDelete(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return accountClose(db, http.MethodDelete)
+}
+
+func AccountCloseCancel(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r, accountClosing)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		status, err := accountStatus(tx, user.ID)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if status != accountClosing {
+			tx.Rollback()
+			util.WriteJSONError(w, errAccountNotClosing)
+
+			return
+		}
+
+		if statusErr := changeAccountStatus(
+			tx, r, user.ID, accountActive, "Closure cancelled by user", user.ID,
+		); statusErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, statusErr)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info("Account %d cancelled its closure.", user.ID)
+		util.WriteJSON(w, map[string]string{
+			"status":         "ok",
+			"account_status": accountActive,
+		})
+	}
+}
+
+func changeAccountStatus(tx *sql.Tx, r *http.Request, userID int64, status, reason string, changedBy interface{}) string {
+	if status != accountActive && status != accountFrozen {
+		return errInvalidAccountStatus
+	}
+
+	current, err := accountStatus(tx, userID)
+	if err == sql.ErrNoRows {
+		return errUserNotFound
+	} else if err != nil {
+		return errInternalErrorOccurred
+	}
+
+	if current == accountClosed {
+		return errAccountClosed
+	}
+
+	if current == status {
+		return ""
+	}
+
+	if err = setAccountStatus(tx, r, userID, status, reason, changedBy); err != nil {
+		return errInternalErrorOccurred
+	}
+
+	message := "Your account has been reactivated."
+	if status == accountFrozen {
+		message = "Your account has been frozen. Contact support for details."
+	}
+
+	if err = notify(tx, userID, "account_"+status, "", message); err != nil {
+		return errInternalErrorOccurred
+	}
+
+	return ""
+}
+
+func SetAccountStatus(db *sql.DB, username, status, reason string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID int64
+	if err = tx.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID); err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return errors.New("user not found: " + username)
+		}
+
+		return err
+	}
+
+	if statusErr := changeAccountStatus(tx, nil, userID, status, reason, nil); statusErr != "" {
+		tx.Rollback()
+		return errors.New(statusErr)
+	}
+
+	return tx.Commit()
+}
+
+func AdminAccountStatus(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		actor := authorizedUser(r)
+
+		var req struct {
+			Username string `json:"username"`
+			Status   string `json:"status"`
+			Reason   string `json:"reason"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Reason) > 140 {
+			util.WriteJSONError(w, errInvalidStatusReason)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		userID, lookupErr := lookupAccount(tx, req.Username)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		var role string
+		if err = tx.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if rbac.Staff(role) && actor.Role != rbac.Admin {
+			tx.Rollback()
+			util.WriteJSONError(w, errPermissionDenied)
+
+			return
+		}
+
+		if userID == actor.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errCannotChangeOwnStatus)
+
+			return
+		}
+
+		if statusErr := changeAccountStatus(tx, r, userID, req.Status, req.Reason, actor.ID); statusErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, statusErr)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info("User %d (%s) set account %d to %s.", actor.ID, actor.Role, userID, req.Status)
+		util.WriteJSON(w, map[string]string{
+			"status":         "ok",
+			"account_status": req.Status,
+		})
+	}
+}
@@ -0,0 +1,616 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/rbac"
+	"github.com/nthnn/ura/util"
+)
+
+const (
+	adjustmentCredit = "credit"
+	adjustmentDebit  = "debit"
+
+	userPageSize = 25
+)
+
+var (
+	errInvalidSearchQuery       = "Search query must be at most 320 characters"
+	errInvalidRole              = "Invalid role"
+	errInvalidAdjustment        = "Direction must be credit or debit"
+	errAdjustmentReasonRequired = "A reason of at most 140 characters is required"
+	errCannotAdjustOwnBalance   = "Cannot adjust own balance"
+	errCannotChangeOwnRole      = "Cannot change own role"
+)
+
+func lookupAccount(q queryer, username string) (int64, string) {
+	if !util.ValidateUsername(username) {
+		return 0, errUserNotFound
+	}
+
+	var userID int64
+	err := q.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		return 0, errUserNotFound
+	} else if err != nil {
+		logger.Error("Error querying user: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	return userID, ""
+}
+
+func AdminUserSearch(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var req struct {
+			Query  string `json:"query"`
+			Role   string `json:"role"`
+			Status string `json:"status"`
+			Cursor string `json:"cursor"`
+			Limit  string `json:"limit"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if len(req.Query) > 320 {
+			util.WriteJSONError(w, errInvalidSearchQuery)
+			return
+		}
+
+		if req.Role != "" && !rbac.ValidRole(req.Role) {
+			util.WriteJSONError(w, errInvalidRole)
+			return
+		}
+
+		if req.Status != "" && req.Status != accountActive && accountStateError(req.Status) == "" {
+			util.WriteJSONError(w, errInvalidAccountStatus)
+			return
+		}
+
+		limit := userPageSize
+		if req.Limit != "" {
+			n, err := strconv.Atoi(req.Limit)
+			if err != nil || n < 1 || n > transactionMaxPageSize {
+				util.WriteJSONError(w, errInvalidPageSize)
+				return
+			}
+
+			limit = n
+		}
+
+		var after int64
+		if req.Cursor != "" {
+			n, err := strconv.ParseInt(req.Cursor, 10, 64)
+			if err != nil || n <= 0 {
+				util.WriteJSONError(w, errInvalidCursor)
+				return
+			}
+
+			after = n
+		}
+
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(req.Query) + "%"
+		rows, err := db.Query(
+			`SELECT id, username, email, identifier, role, COALESCE(tier, ''), status, balance_ura, created_at
+			 FROM users
+			 WHERE (?1 = '' OR username LIKE ?2 ESCAPE '\' OR email LIKE ?2 ESCAPE '\' OR identifier = ?1)
+			 AND (?3 = '' OR role = ?3)
+			 AND (?4 = '' OR status = ?4)
+			 AND id > ?5
+			 ORDER BY id LIMIT ?6`,
+			req.Query, pattern, req.Role, req.Status, after, limit+1,
+		)
+		if err != nil {
+			logger.Error("Error searching users: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+		defer rows.Close()
+
+		users := []map[string]interface{}{}
+		hasMore := false
+		var last int64
+
+		for rows.Next() {
+			var id int64
+			var username, email, identifier, role, tier, status, createdAt string
+			var balance money.Amount
+
+			if err = rows.Scan(
+				&id, &username, &email, &identifier, &role, &tier, &status, &balance, &createdAt,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			if len(users) == limit {
+				hasMore = true
+				break
+			}
+
+			users = append(users, map[string]interface{}{
+				"id":          id,
+				"username":    username,
+				"email":       email,
+				"identifier":  identifier,
+				"role":        role,
+				"tier":        limitPolicy.TierName(tier),
+				"status":      status,
+				"balance_ura": balance,
+				"created_at":  createdAt,
+			})
+			last = id
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		nextCursor := ""
+		if hasMore {
+			nextCursor = strconv.FormatInt(last, 10)
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":      "ok",
+			"users":       users,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		})
+	}
+}
+
+func AdminUserView(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		userID, lookupErr := lookupAccount(db, req.Username)
+		if lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		var user User
+		var createdAt string
+		var statusReason, statusChangedAt, closedAt sql.NullString
+
+		if err := db.QueryRow(
+			`SELECT id, username, email, identifier, balance_ura, role, COALESCE(tier, ''), status,
+			 status_reason, status_changed_at, closed_at, created_at
+			 FROM users WHERE id = ?`,
+			userID,
+		).Scan(
+			&user.ID, &user.Username, &user.Email, &user.Identifier, &user.BalanceUra,
+			&user.Role, &user.Tier, &user.Status, &statusReason, &statusChangedAt,
+			&closedAt, &createdAt,
+		); err != nil {
+			logger.Error("Error querying user: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		user.Tier = limitPolicy.TierName(user.Tier)
+		user.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+
+		var err error
+		if user.AvailableUra, err = availableBalance(db, user.ID, money.BaseCurrency); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		balances, err := walletBalances(db, user.ID)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		available, err := availableBalances(db, user.ID, balances)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT c.from_status, c.to_status, COALESCE(c.reason, ''), COALESCE(u.username, ''), c.created_at
+			 FROM account_status_changes c LEFT JOIN users u ON u.id = c.changed_by
+			 WHERE c.user_id = ? ORDER BY c.id DESC`,
+			user.ID,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		history := []map[string]string{}
+		for rows.Next() {
+			var from, to, reason, changedBy, changedAt string
+			if err = rows.Scan(&from, &to, &reason, &changedBy, &changedAt); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			history = append(history, map[string]string{
+				"from":       from,
+				"to":         to,
+				"reason":     reason,
+				"changed_by": changedBy,
+				"created_at": changedAt,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":            "ok",
+			"user":              user,
+			"balances":          balances,
+			"available":         available,
+			"status_reason":     statusReason.String,
+			"status_changed_at": statusChangedAt.String,
+			"closed_at":         closedAt.String,
+			"status_history":    history,
+		})
+	}
+}
+
+func AdminUserTransactions(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+			transactionQuery
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		userID, lookupErr := lookupAccount(db, req.Username)
+		if lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		writeTransactionPage(w, db, userID, req.transactionQuery)
+	}
+}
+
+func AdminBalanceAdjust(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		admin := authorizedUser(r)
+
+		var req struct {
+			Username  string `json:"username"`
+			Direction string `json:"direction"`
+			Amount    string `json:"amount"`
+			Currency  string `json:"currency"`
+			Reason    string `json:"reason"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Direction != adjustmentCredit && req.Direction != adjustmentDebit {
+			util.WriteJSONError(w, errInvalidAdjustment)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		reason := strings.TrimSpace(req.Reason)
+		if reason == "" || utf8.RuneCountInString(reason) > 140 {
+			util.WriteJSONError(w, errAdjustmentReasonRequired)
+			return
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		if _, lookupErr := exchangeEntry(db, currency); lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		transactionID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		userID, lookupErr := lookupAccount(tx, req.Username)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		if userID == admin.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errCannotAdjustOwnBalance)
+
+			return
+		}
+
+		status, err := accountStatus(tx, userID)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if status == accountClosed {
+			tx.Rollback()
+			util.WriteJSONError(w, errAccountClosed)
+
+			return
+		}
+
+		source, target := ledger.Adjustments(currency), ledger.WalletIn(userID, currency)
+		category, signed := "adjustment_incoming", amount
+		if req.Direction == adjustmentDebit {
+			source, target = target, source
+			category, signed = "adjustment_outgoing", -amount
+		}
+
+		err = ledger.Post(
+			tx, transactionID, "adjustment",
+			ledger.Debit(source, amount),
+			ledger.Credit(target, amount),
+		)
+
+		if err == ledger.ErrInsufficientFunds {
+			tx.Rollback()
+			util.WriteJSONError(w, errInsufficientFunds)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		available, err := availableBalance(tx, userID, currency)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if available < 0 {
+			tx.Rollback()
+			util.WriteJSONError(w, errInsufficientFunds)
+
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err = tx.Exec(
+			`INSERT INTO transactions
+			 (transaction_id, user_id, category, amount, created_at, processed, settled_by, settled_at, memo, currency)
+			 VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?)`,
+			transactionID, userID, category, amount, now, admin.ID, now, reason, currency,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if _, err = tx.Exec(
+			`INSERT INTO balance_adjustments (transaction_id, user_id, admin_id, currency, amount, reason, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			transactionID, userID, admin.ID, currency, signed, reason, now,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = notify(
+			tx, userID, "balance_adjusted", transactionID,
+			"Your balance was adjusted ("+req.Direction+" "+formatAmount(amount, currency)+"): "+reason,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.BalanceAdjusted, admin.ID, userID, transactionID, map[string]interface{}{
+			"direction": req.Direction,
+			"amount":    amount,
+			"currency":  currency,
+			"reason":    reason,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info(
+			"Admin %d adjusted user %d balance by %s %s.",
+			admin.ID, userID, signed.String(), currency,
+		)
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"amount":         signed,
+			"currency":       currency,
+		})
+	}
+}
+
+func AdminRoleAssign(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		admin := authorizedUser(r)
+
+		var req struct {
+			Username string `json:"username"`
+			Role     string `json:"role"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !rbac.ValidRole(req.Role) {
+			util.WriteJSONError(w, errInvalidRole)
+			return
+		}
+
+		userID, lookupErr := lookupAccount(db, req.Username)
+		if lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		if userID == admin.ID {
+			util.WriteJSONError(w, errCannotChangeOwnRole)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var previous string
+		if err = tx.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&previous); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if _, err = tx.Exec("UPDATE users SET role = ? WHERE id = ?", req.Role, userID); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.RoleAssigned, admin.ID, userID, "", map[string]string{
+			"from": previous,
+			"to":   req.Role,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info("Admin %d assigned role %s to user %d.", admin.ID, req.Role, userID)
+		util.WriteJSON(w, map[string]string{
+			"status": "ok",
+			"role":   req.Role,
+		})
+	}
+}
+
+func AdminLedgerVerify(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		mismatches, err := ledger.Verify(db)
+		if err != nil {
+			logger.Error("Ledger verification failed: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if mismatches == nil {
+			mismatches = []ledger.Mismatch{}
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"balanced":   len(mismatches) == 0,
+			"mismatches": mismatches,
+		})
+	}
+}
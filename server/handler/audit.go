@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/util"
+)
+
+var errInvalidAuditAction = "Audit action must be at most 64 characters"
+
+func auditEvent(r *http.Request, action string, actorID, subjectID int64, resource string, payload interface{}) audit.Event {
+	data, err := json.Marshal(payload)
+	if err != nil || payload == nil {
+		data = []byte("{}")
+	}
+
+	event := audit.Event{
+		Action:    action,
+		ActorID:   actorID,
+		SubjectID: subjectID,
+		Resource:  resource,
+		Payload:   data,
+	}
+
+	if r != nil {
+		event.IP = util.ClientIP(r)
+		event.UserAgent = r.UserAgent()
+
+		if len(event.UserAgent) > 512 {
+			event.UserAgent = event.UserAgent[:512]
+		}
+	}
+
+	return event
+}
+
+func recordAudit(
+	tx *sql.Tx,
+	r *http.Request,
+	action string,
+	actorID, subjectID int64,
+	resource string,
+	payload interface{},
+) error {
+	err := audit.Record(tx, auditEvent(r, action, actorID, subjectID, resource, payload))
+	if err != nil {
+		logger.Error("Error recording %s audit entry: %s", action, err.Error())
+	}
+
+	return err
+}
+
+func logAudit(
+	db *sql.DB,
+	r *http.Request,
+	action string,
+	actorID, subjectID int64,
+	resource string,
+	payload interface{},
+) error {
+	err := audit.Log(db, auditEvent(r, action, actorID, subjectID, resource, payload))
+	if err != nil {
+		logger.Error("Error recording %s audit entry: %s", action, err.Error())
+	}
+
+	return err
+}
+
+func AdminAuditLog(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+			Action   string `json:"action"`
+			Cursor   string `json:"cursor"`
+			Limit    string `json:"limit"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if len(req.Action) > 64 {
+			util.WriteJSONError(w, errInvalidAuditAction)
+			return
+		}
+
+		var userID int64
+		if req.Username != "" {
+			var lookupErr string
+			if userID, lookupErr = lookupAccount(db, req.Username); lookupErr != "" {
+				util.WriteJSONError(w, lookupErr)
+				return
+			}
+		}
+
+		limit := transactionPageSize
+		if req.Limit != "" {
+			n, err := strconv.Atoi(req.Limit)
+			if err != nil || n < 1 || n > transactionMaxPageSize {
+				util.WriteJSONError(w, errInvalidPageSize)
+				return
+			}
+
+			limit = n
+		}
+
+		var before int64
+		if req.Cursor != "" {
+			n, err := strconv.ParseInt(req.Cursor, 10, 64)
+			if err != nil || n <= 0 {
+				util.WriteJSONError(w, errInvalidCursor)
+				return
+			}
+
+			before = n
+		}
+
+		rows, err := db.Query(
+			`SELECT id, created_at, action, COALESCE(actor_id, 0), COALESCE(subject_id, 0),
+			 ip, user_agent, resource, payload, payload_hash, prev_hash, hash
+			 FROM audit_log
+			 WHERE (?1 = 0 OR actor_id = ?1 OR subject_id = ?1)
+			 AND (?2 = '' OR action = ?2)
+			 AND (?3 = 0 OR id < ?3)
+			 ORDER BY id DESC LIMIT ?4`,
+			userID, req.Action, before, limit+1,
+		)
+		if err != nil {
+			logger.Error("Error querying audit log: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+		defer rows.Close()
+
+		entries := []map[string]interface{}{}
+		hasMore := false
+		var last int64
+
+		for rows.Next() {
+			var entry audit.Entry
+			var payload, prevHash, hash string
+
+			if err = rows.Scan(
+				&entry.ID, &entry.CreatedAt, &entry.Action, &entry.ActorID, &entry.SubjectID,
+				&entry.IP, &entry.UserAgent, &entry.Resource, &payload, &entry.PayloadHash,
+				&prevHash, &hash,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			if len(entries) == limit {
+				hasMore = true
+				break
+			}
+
+			entries = append(entries, map[string]interface{}{
+				"id":           entry.ID,
+				"created_at":   entry.CreatedAt,
+				"action":       entry.Action,
+				"actor_id":     entry.ActorID,
+				"subject_id":   entry.SubjectID,
+				"ip":           entry.IP,
+				"user_agent":   entry.UserAgent,
+				"resource":     entry.Resource,
+				"payload":      json.RawMessage(payload),
+				"payload_hash": entry.PayloadHash,
+				"prev_hash":    prevHash,
+				"hash":         hash,
+			})
+			last = entry.ID
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		nextCursor := ""
+		if hasMore {
+			nextCursor = strconv.FormatInt(last, 10)
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":      "ok",
+			"entries":     entries,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		})
+	}
+}
+
+func AdminAuditVerify(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		report, err := audit.Verify(db)
+		if err != nil {
+			logger.Error("Audit log verification failed: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":  "ok",
+			"intact":  len(report.Breaks) == 0,
+			"entries": report.Entries,
+			"head":    report.Head,
+			"breaks":  report.Breaks,
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package handler
 
 import (
+	"context"
 	"crypto/subtle"
 	"database/sql"
 	"net/http"
 	"time"
 
 	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/rbac"
 	"github.com/nthnn/ura/util"
 )
 
@@ -59,7 +61,7 @@ func authenticate(db *sql.DB, r *http.Request) (*User, string) {
 	var createdAtStr string
 
 	err = db.QueryRow(
-		"SELECT id, username, email, identifier, security_code, balance_ura, created_at FROM users WHERE id = ?",
+		"SELECT id, username, email, identifier, security_code, balance_ura, role, COALESCE(tier, ''), status, created_at FROM users WHERE id = ?",
 		userID,
 	).Scan(
 		&user.ID,
@@ -68,6 +70,9 @@ func authenticate(db *sql.DB, r *http.Request) (*User, string) {
 		&user.Identifier,
 		&user.SecurityCode,
 		&user.BalanceUra,
+		&user.Role,
+		&user.Tier,
+		&user.Status,
 		&createdAtStr,
 	)
 
@@ -90,5 +95,47 @@ func authenticate(db *sql.DB, r *http.Request) (*User, string) {
 		return nil, errInvalidLoginCredentials
 	}
 
+	if user.Status == accountClosed {
+		return nil, errAccountClosed
+	}
+
+	user.Tier = limitPolicy.TierName(user.Tier)
 	return &user, ""
 }
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+var errPermissionDenied = "Permission denied"
+
+func Authorize(
+	permission rbac.Permission,
+	next func(*sql.DB) func(http.ResponseWriter, *http.Request),
+) func(*sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+		callback := next(db)
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			user, authErr := authenticateActive(db, r)
+			if authErr != "" {
+				util.WriteJSONError(w, authErr)
+				return
+			}
+
+			if !rbac.Allows(user.Role, permission) {
+				logger.Info("User %d (%s) denied %s on %s.", user.ID, user.Role, permission, r.URL.Path)
+				util.WriteJSONError(w, errPermissionDenied)
+
+				return
+			}
+
+			callback(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+		}
+	}
+}
+
+func authorizedUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
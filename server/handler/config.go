@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/nthnn/ura/calendar"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/interest"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+)
+
+var (
+	businessCalendar = calendar.Default()
+	limitPolicy      = policy.Default()
+	interestPlan     = interest.Default()
+	feeSchedule      = fee.Default()
+	voucherTTL       = 24 * time.Hour
+)
+
+func UseCalendar(c *calendar.Calendar) {
+	businessCalendar = c
+}
+
+func UsePolicy(e *policy.Engine) {
+	limitPolicy = e
+}
+
+func UseInterest(p *interest.Plan) {
+	interestPlan = p
+}
+
+func UseFees(s *fee.Schedule) {
+	feeSchedule = s
+}
+
+func UseVoucherTTL(d time.Duration) {
+	voucherTTL = d
+}
+
+func checkPolicy(tier string, activity policy.Activity) string {
+	if violation := limitPolicy.Evaluate(tier, activity); violation != nil {
+		return violation.Error()
+	}
+
+	return ""
+}
+
+func userTier(tx *sql.Tx, userID int64) (string, error) {
+	var tier string
+	err := tx.QueryRow(
+		"SELECT COALESCE(tier, '') FROM users WHERE id = ?",
+		userID,
+	).Scan(&tier)
+
+	return tier, err
+}
+
+func receivedInWindow(tx *sql.Tx, userID int64, tier string) (money.Amount, error) {
+	since := businessCalendar.SubtractBusinessDays(
+		time.Now(),
+		limitPolicy.Tier(tier).ReceivedWindow,
+	)
+
+	var received money.Amount
+	err := tx.QueryRow(
+		`SELECT COALESCE(SUM(COALESCE(base_amount, amount)), 0) FROM transactions
+		 WHERE user_id = ? AND created_at > ? AND category = 'incoming' AND processed = 1`,
+		userID, since.Format(time.RFC3339),
+	).Scan(&received)
+
+	return received, err
+}
@@ -6,32 +6,31 @@ import (
 	"net/http"
 	"strconv"
 	"time"
+	"unicode/utf8"
 
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
 	"github.com/nthnn/ura/util"
 )
 
 var (
 	timeoutMinute time.Duration = 6
 
-	errMethodNotAllowed                   = "Method Not Allowed"
-	errInvalidRequest                     = "Invalid request body"
-	errInvalidUsername                    = "Username cannot contain punctuations except underscore"
-	errInvalidSignupCredentials           = "Invalid credentials"
-	errInternalErrorOccurred              = "Internal error occurred"
-	errUserStillActive                    = "Account has recent activity"
-	errPaymentRequestNotFound             = "Payment request not found"
-	errCannotPayOwnAccount                = "Cannot process payment to self"
-	errInsufficientFunds                  = "Insufficient funds"
-	errPaymentExceeds100kUro              = "Payment amount exceeds 100k uro"
-	errExceededReceivedFunds              = "Recipient received funds limit exceeded in past 2 business days"
-	errPaymentAlreadyProcessed            = "Payment request already processed"
-	errInvalidAmountValue                 = "Invalid amount value"
-	errInvalidWithdrawAmount              = "Withdraw amount cannot be zero or negative value"
-	errInvalidWithdrawAmountExceeds50kUro = "Withdraw amount must be less than 50k uro"
-	errInvalidCashInAmount                = "Cash in amount cannot be zero or negative value"
-	errCashInAmountExceeds100kUro         = "Cash in amount must be less than 100k uro"
-	errCashInEveryIn12Hours               = "Cash in allowed only every 12 hours"
-	errInvalidLoginCredentials            = "Invalid log-in credentials"
+	errMethodNotAllowed         = "Method Not Allowed"
+	errInvalidRequest           = "Invalid request body"
+	errInvalidUsername          = "Username cannot contain punctuations except underscore"
+	errInvalidSignupCredentials = "Invalid credentials"
+	errInternalErrorOccurred    = "Internal error occurred"
+	errPaymentRequestNotFound   = "Payment request not found"
+	errCannotPayOwnAccount      = "Cannot process payment to self"
+	errInsufficientFunds        = "Insufficient funds"
+	errPaymentAlreadyProcessed  = "Payment request already processed"
+	errInvalidAmountValue       = "Invalid amount value"
+	errInvalidWithdrawAmount    = "Withdraw amount cannot be zero or negative value"
+	errInvalidCashInAmount      = "Cash in amount cannot be zero or negative value"
+	errInvalidLoginCredentials  = "Invalid log-in credentials"
 )
 
 func UserCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
@@ -96,19 +95,16 @@ func UserCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		stmt, err := db.Prepare(
-			"INSERT INTO users (username, email, password, identifier, security_code, balance_ura, created_at) " +
-				"VALUES (?, ?, ?, ?, ?, 0, ?)",
-		)
-
+		tx, err := db.Begin()
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
-		defer stmt.Close()
 
 		now := time.Now().UTC().Format(time.RFC3339)
-		_, err = stmt.Exec(
+		res, err := tx.Exec(
+			"INSERT INTO users (username, email, password, identifier, security_code, balance_ura, created_at) "+
+				"VALUES (?, ?, ?, ?, ?, 0, ?)",
 			req.Username,
 			req.Email,
 			req.Password,
@@ -118,62 +114,38 @@ func UserCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 		)
 
 		if err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
-			return
-		}
 
-		util.WriteJSON(w, map[string]interface{}{
-			"status": "ok",
-		})
-	}
-}
-
-func UserDelete(db *sql.DB) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodDelete {
-			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		user, authErr := authenticate(db, r)
-		if authErr != "" {
-			util.WriteJSONError(w, authErr)
-			return
-		}
-
-		var lastActivity string
-		err := db.QueryRow(
-			"SELECT MAX(created_at) FROM transactions WHERE user_id = ?",
-			user.ID,
-		).Scan(&lastActivity)
-
+		userID, err := res.LastInsertId()
 		if err != nil {
-			lastActivity = ""
-		}
-
-		if lastActivity != "" {
-			lastTime, err := time.Parse(time.RFC3339, lastActivity)
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
 
-			if err == nil && time.Since(lastTime) < (30*24*time.Hour) {
-				util.WriteJSONError(w, errUserStillActive)
-				return
-			}
+			return
 		}
 
-		stmt, err := db.Prepare("DELETE FROM users WHERE id = ?")
-		if err != nil {
+		if err = recordAudit(tx, r, audit.UserCreated, userID, userID, "", map[string]string{
+			"username": req.Username,
+			"email":    req.Email,
+		}); err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
+
 			return
 		}
-		defer stmt.Close()
 
-		_, err = stmt.Exec(user.ID)
-		if err != nil {
+		if err = tx.Commit(); err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		util.WriteJSON(w, map[string]string{"status": "ok"})
+		util.WriteJSON(w, map[string]interface{}{
+			"status": "ok",
+		})
 	}
 }
 
@@ -184,7 +156,7 @@ func PaymentProcess(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		payer, authErr := authenticate(db, r)
+		payer, authErr := authenticateActive(db, r)
 		if authErr != "" {
 			util.WriteJSONError(w, authErr)
 			return
@@ -209,91 +181,129 @@ func PaymentProcess(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		var amount float64
-		var recipientID int64
+		if paymentErr := payPaymentRequest(db, r, payer, req.TransactionID, false); paymentErr != "" {
+			util.WriteJSONError(w, paymentErr)
+			return
+		}
 
-		err := db.QueryRow(
-			`SELECT amount, user_id FROM transactions
-			 WHERE transaction_id = ? AND category = 'payment_request'`,
-			req.TransactionID,
-		).Scan(&amount, &recipientID)
+		util.WriteJSON(w, map[string]string{
+			"status":         "ok",
+			"transaction_id": req.TransactionID,
+		})
+	}
+}
 
-		if err != nil {
-			util.WriteJSONError(w, errPaymentRequestNotFound)
+func PaymentRequest(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		if recipientID == payer.ID {
-			util.WriteJSONError(w, errCannotPayOwnAccount)
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
 			return
 		}
 
-		if payer.BalanceUra < amount {
-			util.WriteJSONError(w, errInsufficientFunds)
+		var req struct {
+			Amount    string `json:"amount"`
+			Currency  string `json:"currency"`
+			Payer     string `json:"payer"`
+			Memo      string `json:"memo"`
+			ExpiresIn string `json:"expires_in"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		if amount > 100000 {
-			util.WriteJSONError(w, errPaymentExceeds100kUro)
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
 			return
 		}
-		twoBusinessDaysAgo := time.Now().Add(-48 * time.Hour)
 
-		var receivedSum float64
-		err = db.QueryRow(
-			`SELECT COALESCE(SUM(amount), 0) FROM transactions 
-			 WHERE user_id = ? AND created_at > ? AND category = 'incoming' AND processed = 1`,
-			recipientID, twoBusinessDaysAgo.Format(time.RFC3339),
-		).Scan(&receivedSum)
+		currency := normalizeCurrency(req.Currency)
+		baseAmount, quoteErr := baseEquivalent(db, currency, amount)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
 
-		if err != nil {
-			util.WriteJSONError(w, errInternalErrorOccurred)
+		if policyErr := checkPolicy(user.Tier, policy.Activity{
+			Kind:   policy.Payment,
+			Amount: baseAmount,
+		}); policyErr != "" {
+			util.WriteJSONError(w, policyErr)
 			return
 		}
 
-		if receivedSum >= 50000 {
-			util.WriteJSONError(w, errExceededReceivedFunds)
+		if len(req.Payer) > 320 {
+			util.WriteJSONError(w, errPayerNotFound)
 			return
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			util.WriteJSONError(w, errInternalErrorOccurred)
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
 			return
 		}
 
-		now := time.Now().UTC().Format(time.RFC3339)
-		res, err := tx.Exec(
-			`UPDATE transactions 
-			 SET processed = 1
-			 WHERE transaction_id = ? AND category = 'payment_request' AND processed = 0`,
-			req.TransactionID,
-		)
+		expiresIn := paymentRequestTTL
+		if req.ExpiresIn != "" {
+			hours, err := strconv.Atoi(req.ExpiresIn)
+			if err != nil || hours < 1 || hours > 720 {
+				util.WriteJSONError(w, errInvalidExpiry)
+				return
+			}
+
+			expiresIn = time.Duration(hours) * time.Hour
+		}
 
+		transactionID, err := util.GenerateRandomIdentifier(256)
 		if err != nil {
-			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		rowsAffected, err := res.RowsAffected()
+		now := time.Now().UTC()
+		expiresAt := now.Add(expiresIn).Format(time.RFC3339)
+
+		tx, err := db.Begin()
 		if err != nil {
-			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
-
 			return
 		}
 
-		if rowsAffected == 0 {
-			tx.Rollback()
-			util.WriteJSONError(w, errPaymentAlreadyProcessed)
+		var targetID interface{}
+		if req.Payer != "" {
+			payerID, lookupErr := findUser(tx, req.Payer, errPayerNotFound)
+			if lookupErr != "" {
+				tx.Rollback()
+				util.WriteJSONError(w, lookupErr)
 
-			return
+				return
+			}
+
+			if payerID == user.ID {
+				tx.Rollback()
+				util.WriteJSONError(w, errCannotPayOwnAccount)
+
+				return
+			}
+
+			targetID = payerID
 		}
 
 		if _, err = tx.Exec(
-			"UPDATE users SET balance_ura = balance_ura - ? WHERE id = ?",
-			amount, payer.ID,
+			`INSERT INTO transactions
+			 (transaction_id, user_id, category, amount, created_at, processed, memo, expires_at,
+			  target_user_id, currency)
+			 VALUES (?, ?, 'payment_request', ?, ?, 0, ?, ?, ?, ?)`,
+			transactionID, user.ID, amount, now.Format(time.RFC3339), req.Memo, expiresAt,
+			targetID, currency,
 		); err != nil {
 			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
@@ -301,21 +311,23 @@ func PaymentProcess(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		if _, err = tx.Exec(
-			"UPDATE users SET balance_ura = balance_ura + ? WHERE id = ?",
-			amount, recipientID,
-		); err != nil {
-			tx.Rollback()
-			util.WriteJSONError(w, errInternalErrorOccurred)
+		if payerID, ok := targetID.(int64); ok {
+			if err = notify(
+				tx, payerID, "payment_request_received", transactionID,
+				user.Username+" requested "+formatAmount(amount, currency)+" from you.",
+			); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
 
-			return
+				return
+			}
 		}
 
-		if _, err = tx.Exec(
-			`INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed)
-			 VALUES (?, ?, 'incoming', ?, ?, 1)`,
-			req.TransactionID, recipientID, amount, now,
-		); err != nil {
+		if err = recordAudit(tx, r, audit.PaymentRequested, user.ID, user.ID, transactionID, map[string]interface{}{
+			"amount":   amount,
+			"currency": currency,
+			"payer":    targetID,
+		}); err != nil {
 			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
 
@@ -329,19 +341,20 @@ func PaymentProcess(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 
 		util.WriteJSON(w, map[string]string{
 			"status":         "ok",
-			"transaction_id": req.TransactionID,
+			"transaction_id": transactionID,
+			"expires_at":     expiresAt,
 		})
 	}
 }
 
-func PaymentRequest(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+func Withdraw(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		user, authErr := authenticate(db, r)
+		user, authErr := authenticateActive(db, r, accountClosing)
 		if authErr != "" {
 			util.WriteJSONError(w, authErr)
 			return
@@ -357,61 +370,63 @@ func PaymentRequest(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		if !util.ValidateNumbers(req.Amount) {
-			util.WriteJSONError(w, errInvalidAmountValue)
-			return
-		}
-
-		amount, err := strconv.ParseFloat(req.Amount, 64)
+		amount, err := money.Parse(req.Amount)
 		if err != nil {
 			util.WriteJSONError(w, errInvalidAmountValue)
 			return
 		}
 
-		if amount > 100000 {
-			util.WriteJSONError(w, errPaymentExceeds100kUro)
+		if amount <= 0 {
+			util.WriteJSONError(w, errInvalidWithdrawAmount)
 			return
 		}
 
-		transactionID, err := util.GenerateRandomIdentifier(256)
+		tx, err := db.Begin()
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		now := time.Now().UTC().Format(time.RFC3339)
-		stmt, err := db.Prepare(
-			"INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed) " +
-				"VALUES (?, ?, 'payment_request', ?, ?, 0)",
-		)
+		voucher, voucherErr := createWithdrawVoucher(tx, user, amount, false)
+		if voucherErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, voucherErr)
 
-		if err != nil {
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.WithdrawRequested, user.ID, user.ID, voucher.transactionID, map[string]interface{}{
+			"amount": voucher.amount,
+			"fee":    voucher.fee,
+		}); err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
+
 			return
 		}
-		defer stmt.Close()
 
-		_, err = stmt.Exec(transactionID, user.ID, amount, now)
-		if err != nil {
+		if err = tx.Commit(); err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		util.WriteJSON(w, map[string]string{
+		util.WriteJSON(w, map[string]interface{}{
 			"status":         "ok",
-			"transaction_id": transactionID,
+			"transaction_id": voucher.transactionID,
+			"fee":            voucher.fee,
+			"expires_at":     voucher.expiresAt,
 		})
 	}
 }
 
-func Withdraw(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+func CashIn(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		user, authErr := authenticate(db, r)
+		user, authErr := authenticateActive(db, r)
 		if authErr != "" {
 			util.WriteJSONError(w, authErr)
 			return
@@ -427,55 +442,14 @@ func Withdraw(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		if !util.ValidateNumbers(req.Amount) {
-			util.WriteJSONError(w, errInvalidAmountValue)
-			return
-		}
-
-		amount, err := strconv.ParseFloat(req.Amount, 64)
+		amount, err := money.Parse(req.Amount)
 		if err != nil {
 			util.WriteJSONError(w, errInvalidAmountValue)
 			return
 		}
 
 		if amount <= 0 {
-			util.WriteJSONError(w, errInvalidWithdrawAmount)
-			return
-		} else if amount >= 50000 {
-			util.WriteJSONError(w, errInvalidWithdrawAmountExceeds50kUro)
-			return
-		}
-		twoBusinessDaysAgo := time.Now().Add(-48 * time.Hour)
-
-		var receivedSum float64
-		err = db.QueryRow(
-			"SELECT COALESCE(SUM(amount),0) FROM transactions "+
-				"WHERE user_id = ? AND created_at > ? AND category = 'incoming'",
-			user.ID,
-			twoBusinessDaysAgo.Format(time.RFC3339),
-		).Scan(&receivedSum)
-
-		if err == nil && receivedSum >= 50000 {
-			util.WriteJSONError(
-				w,
-				"Cannot withdraw after receiving 50k uro in past 2 business days",
-			)
-			return
-		}
-
-		var balanceUra float64
-		err = db.QueryRow(
-			"SELECT balance_ura FROM users WHERE id = ?",
-			user.ID,
-		).Scan(&balanceUra)
-
-		if err != nil {
-			util.WriteJSONError(w, errInternalErrorOccurred)
-			return
-		}
-
-		if balanceUra < amount {
-			util.WriteJSONError(w, errInsufficientFunds)
+			util.WriteJSONError(w, errInvalidCashInAmount)
 			return
 		}
 
@@ -485,116 +459,102 @@ func Withdraw(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		now := time.Now().UTC().Format(time.RFC3339)
-		stmt2, err := db.Prepare(
-			"INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed) " +
-				"VALUES (?, ?, 'withdraw', ?, ?, 0)",
-		)
-
+		tx, err := db.Begin()
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		_, err = stmt2.Exec(transactionID, user.ID, amount, now)
+		var lastCashInStr sql.NullString
+		err = tx.QueryRow(
+			"SELECT MAX(created_at) FROM transactions "+
+				"WHERE user_id = ? AND category = 'cashin'",
+			user.ID,
+		).Scan(&lastCashInStr)
+
 		if err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
-			return
-		}
-
-		stmt2.Close()
-		util.WriteJSON(w, map[string]string{
-			"status":         "ok",
-			"transaction_id": transactionID,
-		})
-	}
-}
 
-func CashIn(db *sql.DB) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		user, authErr := authenticate(db, r)
-		if authErr != "" {
-			util.WriteJSONError(w, authErr)
-			return
+		var lastCashIn time.Time
+		if lastCashInStr.Valid {
+			lastCashIn, _ = time.Parse(time.RFC3339, lastCashInStr.String)
 		}
 
-		var req struct {
-			Amount string `json:"amount"`
-		}
+		if policyErr := checkPolicy(user.Tier, policy.Activity{
+			Kind:       policy.CashIn,
+			Amount:     amount,
+			LastCashIn: lastCashIn,
+			Now:        time.Now(),
+		}); policyErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, policyErr)
 
-		err := json.NewDecoder(r.Body).Decode(&req)
-		if err != nil {
-			util.WriteJSONError(w, errInvalidRequest)
 			return
 		}
 
-		if !util.ValidateNumbers(req.Amount) {
-			util.WriteJSONError(w, errInvalidAmountValue)
-			return
-		}
+		charge, quoteErr := quoteFee(tx, fee.CashIn, user.Tier, money.BaseCurrency, amount)
+		if quoteErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, quoteErr)
 
-		amount, err := strconv.ParseFloat(req.Amount, 64)
-		if err != nil {
-			util.WriteJSONError(w, errInvalidAmountValue)
 			return
 		}
 
-		if amount <= 0 {
-			util.WriteJSONError(w, errInvalidCashInAmount)
-			return
-		} else if amount >= 100000 {
-			util.WriteJSONError(w, errCashInAmountExceeds100kUro)
+		if charge >= amount {
+			tx.Rollback()
+			util.WriteJSONError(w, errFeeExceedsAmount)
+
 			return
 		}
 
-		var lastCashInStr string
-		err = db.QueryRow(
-			"SELECT MAX(created_at) FROM transactions "+
-				"WHERE user_id = ? AND category = 'cashin'",
-			user.ID,
-		).Scan(&lastCashInStr)
+		now := time.Now().UTC()
+		expiresAt := now.Add(voucherTTL).Format(time.RFC3339)
 
-		if err == nil && lastCashInStr != "" {
-			lastCashIn, err := time.Parse(time.RFC3339, lastCashInStr)
+		if _, err = tx.Exec(
+			"INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed, expires_at) "+
+				"VALUES (?, ?, 'cashin', ?, ?, 0, ?)",
+			transactionID, user.ID, amount, now.Format(time.RFC3339), expiresAt,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
 
-			if err == nil && time.Since(lastCashIn) < 12*time.Hour {
-				util.WriteJSONError(w, errCashInEveryIn12Hours)
-				return
-			}
+			return
 		}
 
-		transactionID, err := util.GenerateRandomIdentifier(256)
-		if err != nil {
+		if err = recordFee(
+			tx, user.ID, transactionID, fee.CashIn,
+			money.BaseCurrency, charge, transactionPending,
+		); err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
+
 			return
 		}
 
-		now := time.Now().UTC().Format(time.RFC3339)
-		stmt2, err := db.Prepare(
-			"INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed) " +
-				"VALUES (?, ?, 'cashin', ?, ?, false)",
-		)
-
-		if err != nil {
+		if err = recordAudit(tx, r, audit.CashInRequested, user.ID, user.ID, transactionID, map[string]interface{}{
+			"amount": amount,
+			"fee":    charge,
+		}); err != nil {
+			tx.Rollback()
 			util.WriteJSONError(w, errInternalErrorOccurred)
+
 			return
 		}
 
-		_, err = stmt2.Exec(transactionID, user.ID, amount, now)
-		if err != nil {
+		if err = tx.Commit(); err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
-		stmt2.Close()
-		util.WriteJSON(w, map[string]string{
+		util.WriteJSON(w, map[string]interface{}{
 			"status":         "ok",
 			"transaction_id": transactionID,
+			"fee":            charge,
+			"expires_at":     expiresAt,
 		})
 	}
 }
@@ -631,7 +591,7 @@ func UserLogin(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 		var createdAtStr string
 
 		err = db.QueryRow(
-			"SELECT id, username, email, identifier, security_code, balance_ura, created_at "+
+			"SELECT id, username, email, identifier, security_code, balance_ura, role, status, created_at "+
 				"FROM users WHERE username = ? AND password = ?",
 			req.Username,
 			req.Password,
@@ -642,14 +602,31 @@ func UserLogin(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			&user.Identifier,
 			&user.SecurityCode,
 			&user.BalanceUra,
+			&user.Role,
+			&user.Status,
 			&createdAtStr,
 		)
 
 		if err != nil {
+			logAudit(db, r, audit.UserLoginFailed, 0, 0, "", map[string]string{
+				"username": req.Username,
+				"reason":   "invalid_credentials",
+			})
+
 			util.WriteJSONError(w, errInvalidLoginCredentials)
 			return
 		}
 
+		if user.Status == accountClosed {
+			logAudit(db, r, audit.UserLoginFailed, user.ID, user.ID, "", map[string]string{
+				"username": req.Username,
+				"reason":   "account_closed",
+			})
+
+			util.WriteJSONError(w, errAccountClosed)
+			return
+		}
+
 		sessionToken, err := util.GenerateRandomIdentifier(256)
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
@@ -657,16 +634,32 @@ func UserLogin(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 		}
 
 		expiresAt := time.Now().Add(timeoutMinute * time.Minute).UTC().Format(time.RFC3339)
-		stmt, err := db.Prepare("INSERT INTO sessions (token, user_id, expires_at) VALUES (?, ?, ?)")
-
+		tx, err := db.Begin()
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
-		defer stmt.Close()
 
-		_, err = stmt.Exec(sessionToken, user.ID, expiresAt)
-		if err != nil {
+		if _, err = tx.Exec(
+			"INSERT INTO sessions (token, user_id, expires_at) VALUES (?, ?, ?)",
+			sessionToken, user.ID, expiresAt,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.UserLogin, user.ID, user.ID, "", map[string]string{
+			"session_expires_at": expiresAt,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
@@ -692,47 +685,35 @@ func UserFetchInfo(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
-		rows, err := db.Query(
-			"SELECT transaction_id, category, amount, created_at, processed "+
-				"FROM transactions WHERE user_id = ?",
-			user.ID,
-		)
-
+		balances, err := walletBalances(db, user.ID)
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
-		defer rows.Close()
-
-		var transactions []map[string]interface{}
-		for rows.Next() {
-			var tid, category, createdAt string
-			var processed int
-			var amount float64
 
-			err = rows.Scan(&tid, &category, &amount, &createdAt, &processed)
-			if err != nil {
-				util.WriteJSONError(w, errInternalErrorOccurred)
-				return
-			}
+		available, err := availableBalances(db, user.ID, balances)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
 
-			transactions = append(transactions, map[string]interface{}{
-				"transaction_id": tid,
-				"category":       category,
-				"amount":         amount,
-				"created_at":     createdAt,
-				"processed":      processed,
-			})
+		if user.AvailableUra, err = availableBalance(db, user.ID, money.BaseCurrency); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
 		}
 
-		if err = rows.Err(); err != nil {
+		transactions, hasMore, err := recentTransactions(db, user.ID)
+		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
 			return
 		}
 
 		util.WriteJSON(w, map[string]interface{}{
-			"user":         user,
-			"transactions": transactions,
+			"user":                  user,
+			"balances":              balances,
+			"available":             available,
+			"transactions":          transactions,
+			"has_more_transactions": hasMore,
 		})
 	}
 }
@@ -750,6 +731,15 @@ func UserLogout(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
+		var userID int64
+		if err := db.QueryRow(
+			"SELECT user_id FROM sessions WHERE token = ?",
+			sessionToken,
+		).Scan(&userID); err != nil && err != sql.ErrNoRows {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
 		stmt, err := db.Prepare("DELETE FROM sessions WHERE token = ?")
 		if err != nil {
 			util.WriteJSONError(w, errInternalErrorOccurred)
@@ -762,6 +752,10 @@ func UserLogout(db *sql.DB) func(http.ResponseWriter, *http.Request) {
 			return
 		}
 
+		if userID != 0 {
+			logAudit(db, r, audit.UserLogout, userID, userID, "", nil)
+		}
+
 		util.WriteJSON(w, map[string]string{
 			"status": "ok",
 		})
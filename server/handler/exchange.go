@@ -0,0 +1,507 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fx"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	errUnsupportedCurrency = "Unsupported currency"
+	errInvalidRate         = "Rate must be a positive decimal with at most 8 decimals"
+	errInvalidSpread       = "Spread must be between 0 and 1000 basis points"
+	errSameCurrency        = "Source and target currencies must differ"
+	errAmountTooSmall      = "Amount is too small to convert"
+)
+
+func formatAmount(amount money.Amount, currency string) string {
+	if currency == "" || currency == money.BaseCurrency {
+		return amount.String() + " uro"
+	}
+
+	return amount.String() + " " + currency
+}
+
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return money.BaseCurrency
+	}
+
+	return currency
+}
+
+func exchangeEntry(q queryer, currency string) (fx.Entry, string) {
+	if currency == money.BaseCurrency {
+		return fx.Base(), ""
+	}
+
+	if !fx.ValidCurrency(currency) {
+		return fx.Entry{}, errUnsupportedCurrency
+	}
+
+	var rate string
+	entry := fx.Entry{Currency: currency}
+
+	err := q.QueryRow(
+		"SELECT rate, spread_bps FROM exchange_rates WHERE currency = ?",
+		currency,
+	).Scan(&rate, &entry.SpreadBps)
+
+	if err == sql.ErrNoRows {
+		return fx.Entry{}, errUnsupportedCurrency
+	} else if err != nil {
+		logger.Error("Error querying exchange rate: %s", err.Error())
+		return fx.Entry{}, errInternalErrorOccurred
+	}
+
+	if entry.Rate, err = fx.ParseRate(rate); err != nil {
+		logger.Error("Invalid stored exchange rate for %s: %s", currency, rate)
+		return fx.Entry{}, errInternalErrorOccurred
+	}
+
+	return entry, ""
+}
+
+func quoteExchange(q queryer, from, to string, amount money.Amount) (fx.Quote, string) {
+	fromEntry, lookupErr := exchangeEntry(q, from)
+	if lookupErr != "" {
+		return fx.Quote{}, lookupErr
+	}
+
+	toEntry, lookupErr := exchangeEntry(q, to)
+	if lookupErr != "" {
+		return fx.Quote{}, lookupErr
+	}
+
+	if from == to {
+		return fx.Quote{
+			From:      from,
+			To:        to,
+			Rate:      fx.RateScale,
+			Amount:    amount,
+			Converted: amount,
+		}, ""
+	}
+
+	quote := fx.Cross(fromEntry, toEntry, amount)
+	if quote.Converted <= 0 {
+		return fx.Quote{}, errAmountTooSmall
+	}
+
+	return quote, ""
+}
+
+func baseEquivalent(q queryer, currency string, amount money.Amount) (money.Amount, string) {
+	entry, lookupErr := exchangeEntry(q, currency)
+	if lookupErr != "" {
+		return 0, lookupErr
+	}
+
+	entry.SpreadBps = 0
+	return fx.Cross(entry, fx.Base(), amount).Converted, ""
+}
+
+func exchangePostings(
+	fromAccount ledger.Account,
+	toAccount ledger.Account,
+	quote fx.Quote,
+) []ledger.Posting {
+	if quote.From == quote.To {
+		return []ledger.Posting{
+			ledger.Debit(fromAccount, quote.Amount),
+			ledger.Credit(toAccount, quote.Amount),
+		}
+	}
+
+	return []ledger.Posting{
+		ledger.Debit(fromAccount, quote.Amount),
+		ledger.Credit(ledger.Exchange(quote.From), quote.Amount),
+		ledger.Debit(ledger.Exchange(quote.To), quote.Converted),
+		ledger.Credit(toAccount, quote.Converted),
+	}
+}
+
+func fxColumns(quote fx.Quote) (interface{}, interface{}) {
+	if quote.From == quote.To {
+		return nil, nil
+	}
+
+	return quote.Rate.String(), quote.SpreadBps
+}
+
+func storeExchangeRate(q execer, entry fx.Entry, updatedBy interface{}) error {
+	_, err := q.Exec(
+		`INSERT INTO exchange_rates (currency, rate, spread_bps, updated_by, updated_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(currency) DO UPDATE SET
+		 rate = excluded.rate, spread_bps = excluded.spread_bps,
+		 updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		entry.Currency, entry.Rate.String(), entry.SpreadBps, updatedBy,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+
+	return err
+}
+
+func walletBalances(db *sql.DB, userID int64) (map[string]money.Amount, error) {
+	rows, err := db.Query(
+		"SELECT currency, balance FROM wallet_balances WHERE user_id = ?",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := map[string]money.Amount{}
+	for rows.Next() {
+		var currency string
+		var balance money.Amount
+
+		if err = rows.Scan(&currency, &balance); err != nil {
+			return nil, err
+		}
+
+		balances[currency] = balance
+	}
+
+	return balances, rows.Err()
+}
+
+func LoadExchangeRates(db *sql.DB, path string) error {
+	entries, err := fx.LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err = storeExchangeRate(tx, entry, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func ExchangeRates(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		_, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		rows, err := db.Query(
+			"SELECT currency, rate, spread_bps, updated_at FROM exchange_rates ORDER BY currency",
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		rates := []map[string]interface{}{}
+		for rows.Next() {
+			var currency, rate string
+			var updatedAt sql.NullString
+			var spread int
+
+			if err = rows.Scan(&currency, &rate, &spread, &updatedAt); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			rates = append(rates, map[string]interface{}{
+				"currency":   currency,
+				"rate":       rate,
+				"spread_bps": spread,
+				"updated_at": updatedAt.String,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status": "ok",
+			"base":   money.BaseCurrency,
+			"rates":  rates,
+		})
+	}
+}
+
+func ExchangeQuote(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		_, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			From   string `json:"from"`
+			To     string `json:"to"`
+			Amount string `json:"amount"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		quote, quoteErr := quoteExchange(
+			db,
+			normalizeCurrency(req.From),
+			normalizeCurrency(req.To),
+			amount,
+		)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status": "ok",
+			"quote":  quote,
+		})
+	}
+}
+
+func WalletConvert(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r, accountClosing)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			From   string `json:"from"`
+			To     string `json:"to"`
+			Amount string `json:"amount"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		from := normalizeCurrency(req.From)
+		to := normalizeCurrency(req.To)
+
+		if from == to {
+			util.WriteJSONError(w, errSameCurrency)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		transactionID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		quote, quoteErr := quoteExchange(tx, from, to, amount)
+		if quoteErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, quoteErr)
+
+			return
+		}
+
+		baseAmount, quoteErr := baseEquivalent(tx, from, amount)
+		if quoteErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, quoteErr)
+
+			return
+		}
+
+		err = ledger.Post(
+			tx, transactionID, "exchange",
+			exchangePostings(ledger.WalletIn(user.ID, from), ledger.WalletIn(user.ID, to), quote)...,
+		)
+
+		if err == ledger.ErrInsufficientFunds {
+			tx.Rollback()
+			util.WriteJSONError(w, errInsufficientFunds)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			logger.Error("Error posting exchange: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		rate, spread := fxColumns(quote)
+		now := time.Now().UTC().Format(time.RFC3339)
+
+		if _, err = tx.Exec(
+			`INSERT INTO transactions
+			 (transaction_id, user_id, category, amount, created_at, processed, currency, base_amount,
+			  fx_rate, fx_spread_bps, counter_amount, counter_currency)
+			 VALUES (?, ?, 'exchange_outgoing', ?, ?, 1, ?, ?, ?, ?, ?, ?),
+			        (?, ?, 'exchange_incoming', ?, ?, 1, ?, ?, ?, ?, ?, ?)`,
+			transactionID, user.ID, quote.Amount, now, from, baseAmount, rate, spread, quote.Converted, to,
+			transactionID, user.ID, quote.Converted, now, to, baseAmount, rate, spread, quote.Amount, from,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.CurrencyConverted, user.ID, user.ID, transactionID, map[string]interface{}{
+			"from":      from,
+			"to":        to,
+			"amount":    quote.Amount,
+			"converted": quote.Converted,
+			"rate":      rate,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"quote":          quote,
+		})
+	}
+}
+
+func AdminSetExchangeRate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		admin := authorizedUser(r)
+
+		var req struct {
+			Currency  string `json:"currency"`
+			Rate      string `json:"rate"`
+			SpreadBps int    `json:"spread_bps"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		rate, err := fx.ParseRate(req.Rate)
+		if err != nil {
+			util.WriteJSONError(w, errInvalidRate)
+			return
+		}
+
+		entry := fx.Entry{
+			Currency:  req.Currency,
+			Rate:      rate,
+			SpreadBps: req.SpreadBps,
+		}
+
+		switch entry.Validate() {
+		case nil:
+		case fx.ErrInvalidSpread:
+			util.WriteJSONError(w, errInvalidSpread)
+			return
+		default:
+			util.WriteJSONError(w, errUnsupportedCurrency)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if err = storeExchangeRate(tx, entry, admin.ID); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.ExchangeRateSet, admin.ID, 0, entry.Currency, map[string]interface{}{
+			"rate":       entry.Rate,
+			"spread_bps": entry.SpreadBps,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"currency":   entry.Currency,
+			"rate":       entry.Rate,
+			"spread_bps": entry.SpreadBps,
+		})
+	}
+}
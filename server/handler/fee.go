@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/fx"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	errInvalidFeeKind   = "Fee kind must be payment, withdraw or cashin"
+	errFeeExceedsAmount = "Amount does not cover the fee"
+)
+
+var feeMemos = map[string]string{
+	fee.Payment:  "Payment fee",
+	fee.Withdraw: "Withdraw fee",
+	fee.CashIn:   "Cash-in fee",
+}
+
+func fromBase(q queryer, currency string, amount money.Amount) (money.Amount, string) {
+	entry, lookupErr := exchangeEntry(q, currency)
+	if lookupErr != "" {
+		return 0, lookupErr
+	}
+
+	entry.SpreadBps = 0
+	return fx.Cross(fx.Base(), entry, amount).Converted, ""
+}
+
+func quoteFee(q queryer, kind, tier, currency string, amount money.Amount) (money.Amount, string) {
+	base, lookupErr := baseEquivalent(q, currency, amount)
+	if lookupErr != "" {
+		return 0, lookupErr
+	}
+
+	charge := feeSchedule.Quote(kind, limitPolicy.TierName(tier), base)
+	if charge == 0 || currency == money.BaseCurrency {
+		return charge, ""
+	}
+
+	return fromBase(q, currency, charge)
+}
+
+func postFee(tx *sql.Tx, feeID string, userID int64, currency string, charge money.Amount) error {
+	return ledger.Post(
+		tx, feeID, "fee",
+		ledger.Debit(ledger.WalletIn(userID, currency), charge),
+		ledger.Credit(ledger.Fees(currency), charge),
+	)
+}
+
+func recordFee(
+	tx *sql.Tx,
+	userID int64,
+	parentID string,
+	kind string,
+	currency string,
+	charge money.Amount,
+	status int,
+) error {
+	if charge <= 0 {
+		return nil
+	}
+
+	feeID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		return err
+	}
+
+	if status == transactionProcessed {
+		if err = postFee(tx, feeID, userID, currency, charge); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO transactions
+		 (transaction_id, user_id, category, amount, created_at, processed, memo, related_transaction_id, currency)
+		 VALUES (?, ?, 'fee', ?, ?, ?, ?, ?, ?)`,
+		feeID, userID, charge, time.Now().UTC().Format(time.RFC3339),
+		status, feeMemos[kind], parentID, currency,
+	)
+
+	return err
+}
+
+type pendingFee struct {
+	id       string
+	userID   int64
+	charge   money.Amount
+	currency string
+}
+
+func pendingFees(tx *sql.Tx, parentID string) ([]pendingFee, error) {
+	rows, err := tx.Query(
+		`SELECT transaction_id, user_id, amount, currency FROM transactions
+		 WHERE category = 'fee' AND related_transaction_id = ? AND processed = ?`,
+		parentID, transactionPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fees []pendingFee
+	for rows.Next() {
+		var pending pendingFee
+		if err = rows.Scan(&pending.id, &pending.userID, &pending.charge, &pending.currency); err != nil {
+			return nil, err
+		}
+
+		fees = append(fees, pending)
+	}
+
+	return fees, rows.Err()
+}
+
+func settleFees(tx *sql.Tx, parentID string, status int) error {
+	fees, err := pendingFees(tx, parentID)
+	if err != nil {
+		return err
+	}
+
+	for _, pending := range fees {
+		if status == transactionProcessed {
+			if err = postFee(tx, pending.id, pending.userID, pending.currency, pending.charge); err != nil {
+				return err
+			}
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE transactions SET processed = ?
+			 WHERE transaction_id = ? AND category = 'fee'`,
+			status, pending.id,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func FeeQuote(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Kind     string `json:"kind"`
+			Amount   string `json:"amount"`
+			Currency string `json:"currency"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if _, exists := feeMemos[req.Kind]; !exists {
+			util.WriteJSONError(w, errInvalidFeeKind)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		if req.Kind != fee.Payment {
+			currency = money.BaseCurrency
+		}
+
+		charge, quoteErr := quoteFee(db, req.Kind, user.Tier, currency, amount)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
+
+		total := amount + charge
+		if req.Kind == fee.CashIn {
+			if charge >= amount {
+				util.WriteJSONError(w, errFeeExceedsAmount)
+				return
+			}
+
+			total = amount - charge
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"kind":     req.Kind,
+			"amount":   amount,
+			"fee":      charge,
+			"total":    total,
+			"currency": currency,
+		})
+	}
+}
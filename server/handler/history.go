@@ -0,0 +1,358 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	errInvalidCursor      = "Invalid cursor"
+	errInvalidPageSize    = "Page size must be between 1 and 100"
+	errInvalidDateRange   = "Invalid date range"
+	errInvalidStatus      = "Invalid status filter"
+	errInvalidCategory    = "Invalid category filter"
+	errInvalidAmountRange = "Invalid amount range"
+)
+
+const (
+	transactionPageSize    = 25
+	transactionMaxPageSize = 100
+)
+
+const transactionColumns = `id, transaction_id, category, amount, created_at, processed,
+	related_transaction_id, currency, fx_rate, counter_amount, counter_currency`
+
+const transactionFilter = `user_id = ?1
+	AND (?2 IS NULL OR created_at >= ?2)
+	AND (?3 IS NULL OR created_at < ?3)
+	AND (?4 IS NULL OR category = ?4)
+	AND (?5 IS NULL OR processed = ?5)
+	AND (?6 IS NULL OR amount >= ?6)
+	AND (?7 IS NULL OR amount <= ?7)
+	AND (?8 IS NULL OR currency = ?8)`
+
+type transactionCursor struct {
+	createdAt string
+	id        int64
+}
+
+func (c transactionCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(c.createdAt + "," + strconv.FormatInt(c.id, 10)),
+	)
+}
+
+func parseTransactionCursor(s string) (transactionCursor, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return transactionCursor{}, false
+	}
+
+	createdAt, id, found := strings.Cut(string(data), ",")
+	if !found {
+		return transactionCursor{}, false
+	}
+
+	if _, err = time.Parse(time.RFC3339, createdAt); err != nil {
+		return transactionCursor{}, false
+	}
+
+	cursorID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || cursorID <= 0 {
+		return transactionCursor{}, false
+	}
+
+	return transactionCursor{createdAt: createdAt, id: cursorID}, true
+}
+
+func parseDateBound(s string, end bool) (interface{}, bool) {
+	if s == "" {
+		return nil, true
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Format(time.RFC3339), true
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, false
+	}
+
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t.Format(time.RFC3339), true
+}
+
+func parseAmountBound(s string) (interface{}, bool) {
+	if s == "" {
+		return nil, true
+	}
+
+	amount, err := money.Parse(s)
+	if err != nil || amount < 0 {
+		return nil, false
+	}
+
+	return amount, true
+}
+
+func scanTransaction(rows *sql.Rows) (int64, map[string]interface{}, error) {
+	var id int64
+	var tid, category, createdAt, currency string
+	var relatedID, fxRate, counterCurrency sql.NullString
+	var counterAmount sql.NullInt64
+	var processed int
+	var amount money.Amount
+
+	if err := rows.Scan(
+		&id, &tid, &category, &amount, &createdAt, &processed, &relatedID,
+		&currency, &fxRate, &counterAmount, &counterCurrency,
+	); err != nil {
+		return 0, nil, err
+	}
+
+	return id, map[string]interface{}{
+		"transaction_id":         tid,
+		"category":               category,
+		"amount":                 amount,
+		"created_at":             createdAt,
+		"processed":              processed,
+		"related_transaction_id": relatedID.String,
+		"currency":               currency,
+		"fx_rate":                fxRate.String,
+		"counter_amount":         money.Amount(counterAmount.Int64),
+		"counter_currency":       counterCurrency.String,
+	}, nil
+}
+
+func recentTransactions(db *sql.DB, userID int64) ([]map[string]interface{}, bool, error) {
+	rows, err := db.Query(
+		`SELECT `+transactionColumns+` FROM (
+		   SELECT * FROM transactions WHERE user_id = ? ORDER BY id DESC LIMIT ?
+		 ) ORDER BY id`,
+		userID, transactionMaxPageSize+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	transactions := []map[string]interface{}{}
+	for rows.Next() {
+		_, transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, false, err
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(transactions) > transactionMaxPageSize
+	if hasMore {
+		transactions = transactions[1:]
+	}
+
+	return transactions, hasMore, nil
+}
+
+type transactionQuery struct {
+	Cursor    string `json:"cursor"`
+	Limit     string `json:"limit"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Category  string `json:"category"`
+	Status    string `json:"status"`
+	MinAmount string `json:"min_amount"`
+	MaxAmount string `json:"max_amount"`
+	Currency  string `json:"currency"`
+}
+
+func writeTransactionPage(w http.ResponseWriter, db *sql.DB, userID int64, req transactionQuery) {
+	limit := transactionPageSize
+	if req.Limit != "" {
+		n, err := strconv.Atoi(req.Limit)
+		if err != nil || n < 1 || n > transactionMaxPageSize {
+			util.WriteJSONError(w, errInvalidPageSize)
+			return
+		}
+
+		limit = n
+	}
+
+	var cursor *transactionCursor
+	if req.Cursor != "" {
+		c, ok := parseTransactionCursor(req.Cursor)
+		if !ok {
+			util.WriteJSONError(w, errInvalidCursor)
+			return
+		}
+
+		cursor = &c
+	}
+
+	from, fromOk := parseDateBound(req.From, false)
+	to, toOk := parseDateBound(req.To, true)
+	if !fromOk || !toOk {
+		util.WriteJSONError(w, errInvalidDateRange)
+		return
+	}
+
+	if from != nil && to != nil && from.(string) >= to.(string) {
+		util.WriteJSONError(w, errInvalidDateRange)
+		return
+	}
+
+	var category interface{}
+	if req.Category != "" {
+		if len(req.Category) > 32 {
+			util.WriteJSONError(w, errInvalidCategory)
+			return
+		}
+
+		category = req.Category
+	}
+
+	var status interface{}
+	if req.Status != "" {
+		code, ok := transactionStatuses[req.Status]
+		if !ok {
+			util.WriteJSONError(w, errInvalidStatus)
+			return
+		}
+
+		status = code
+	}
+
+	minAmount, minOk := parseAmountBound(req.MinAmount)
+	maxAmount, maxOk := parseAmountBound(req.MaxAmount)
+	if !minOk || !maxOk {
+		util.WriteJSONError(w, errInvalidAmountRange)
+		return
+	}
+
+	if minAmount != nil && maxAmount != nil &&
+		minAmount.(money.Amount) > maxAmount.(money.Amount) {
+		util.WriteJSONError(w, errInvalidAmountRange)
+		return
+	}
+
+	var currency interface{}
+	if req.Currency != "" {
+		currency = req.Currency
+	}
+
+	args := []interface{}{
+		userID, from, to, category, status, minAmount, maxAmount, currency,
+	}
+
+	var total int
+	if err := db.QueryRow(
+		"SELECT COUNT(*) FROM transactions WHERE "+transactionFilter,
+		args...,
+	).Scan(&total); err != nil {
+		logger.Error("Error counting transactions: %s", err.Error())
+		util.WriteJSONError(w, errInternalErrorOccurred)
+
+		return
+	}
+
+	var cursorAt, cursorID interface{}
+	if cursor != nil {
+		cursorAt, cursorID = cursor.createdAt, cursor.id
+	}
+
+	rows, err := db.Query(
+		"SELECT "+transactionColumns+" FROM transactions WHERE "+transactionFilter+`
+		 AND (?9 IS NULL OR created_at < ?9 OR (created_at = ?9 AND id < ?10))
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT ?11`,
+		append(args, cursorAt, cursorID, limit+1)...,
+	)
+	if err != nil {
+		logger.Error("Error querying transactions: %s", err.Error())
+		util.WriteJSONError(w, errInternalErrorOccurred)
+
+		return
+	}
+	defer rows.Close()
+
+	transactions := []map[string]interface{}{}
+	var last transactionCursor
+	hasMore := false
+
+	for rows.Next() {
+		id, transaction, err := scanTransaction(rows)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if len(transactions) == limit {
+			hasMore = true
+			break
+		}
+
+		transactions = append(transactions, transaction)
+		last = transactionCursor{
+			createdAt: transaction["created_at"].(string),
+			id:        id,
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		util.WriteJSONError(w, errInternalErrorOccurred)
+		return
+	}
+
+	nextCursor := ""
+	if hasMore {
+		nextCursor = last.String()
+	}
+
+	util.WriteJSON(w, map[string]interface{}{
+		"status":       "ok",
+		"transactions": transactions,
+		"total":        total,
+		"has_more":     hasMore,
+		"next_cursor":  nextCursor,
+	})
+}
+
+func TransactionList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req transactionQuery
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		writeTransactionPage(w, db, user.ID, req)
+	}
+}
@@ -0,0 +1,657 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/util"
+)
+
+const (
+	holdAuthorized = "authorized"
+	holdCaptured   = "captured"
+	holdVoided     = "voided"
+	holdExpired    = "expired"
+
+	holdKindPayment  = "payment"
+	holdKindWithdraw = "withdraw"
+)
+
+var (
+	holdTTL = 7 * 24 * time.Hour
+
+	errHoldNotFound          = "Hold not found"
+	errHoldNotAuthorized     = "Hold is no longer authorized"
+	errHoldExpired           = "Hold has expired"
+	errCaptureExceedsHold    = "Capture amount exceeds the authorized amount"
+	errOnlyRecipientCaptures = "Only the hold recipient can capture it"
+	errInvalidHoldStatus     = "Status must be authorized, captured, voided or expired"
+)
+
+type Hold struct {
+	HoldID        string       `json:"hold_id"`
+	Kind          string       `json:"kind"`
+	Payer         string       `json:"payer"`
+	Recipient     string       `json:"recipient"`
+	Amount        money.Amount `json:"amount"`
+	Captured      money.Amount `json:"captured"`
+	Currency      string       `json:"currency"`
+	Memo          string       `json:"memo"`
+	Status        string       `json:"status"`
+	TransactionID string       `json:"transaction_id"`
+	ExpiresAt     string       `json:"expires_at"`
+	CreatedAt     string       `json:"created_at"`
+}
+
+type holdRecord struct {
+	id          int64
+	userID      int64
+	recipientID int64
+	amount      money.Amount
+	currency    string
+	status      string
+}
+
+func expireHolds(q execer) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := q.Exec(
+		`UPDATE holds SET status = ?, updated_at = ?
+		 WHERE status = ? AND expires_at <= ?`,
+		holdExpired, now, holdAuthorized, now,
+	)
+
+	if err != nil {
+		logger.Error("Error expiring holds: %s", err.Error())
+	}
+	return err
+}
+
+func RunHoldExpiry(db *sql.DB) func(time.Time) error {
+	return func(now time.Time) error {
+		return expireHolds(db)
+	}
+}
+
+func walletBalance(q queryer, userID int64, currency string) (money.Amount, error) {
+	var balance money.Amount
+	var err error
+
+	if currency == money.BaseCurrency {
+		err = q.QueryRow(
+			"SELECT balance_ura FROM users WHERE id = ?",
+			userID,
+		).Scan(&balance)
+	} else {
+		err = q.QueryRow(
+			"SELECT COALESCE(SUM(balance), 0) FROM wallet_balances WHERE user_id = ? AND currency = ?",
+			userID, currency,
+		).Scan(&balance)
+	}
+
+	return balance, err
+}
+
+func availableBalance(q queryer, userID int64, currency string) (money.Amount, error) {
+	balance, err := walletBalance(q, userID, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	held, err := ledger.Held(q, userID, currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance - held, nil
+}
+
+func availableBalances(db *sql.DB, userID int64, balances map[string]money.Amount) (map[string]money.Amount, error) {
+	available := map[string]money.Amount{}
+	for currency := range balances {
+		amount, err := availableBalance(db, userID, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		available[currency] = amount
+	}
+
+	return available, nil
+}
+
+func findHold(tx *sql.Tx, holdID string) (holdRecord, string) {
+	var hold holdRecord
+	err := tx.QueryRow(
+		`SELECT id, user_id, recipient_id, amount, currency, status
+		 FROM holds WHERE hold_id = ? AND kind = ?`,
+		holdID, holdKindPayment,
+	).Scan(&hold.id, &hold.userID, &hold.recipientID, &hold.amount, &hold.currency, &hold.status)
+
+	if err == sql.ErrNoRows {
+		return hold, errHoldNotFound
+	} else if err != nil {
+		logger.Error("Error querying hold: %s", err.Error())
+		return hold, errInternalErrorOccurred
+	}
+
+	if hold.status == holdExpired {
+		return hold, errHoldExpired
+	} else if hold.status != holdAuthorized {
+		return hold, errHoldNotAuthorized
+	}
+
+	return hold, ""
+}
+
+func closeHold(tx *sql.Tx, hold holdRecord, status string, captured money.Amount, transactionID interface{}) string {
+	res, err := tx.Exec(
+		`UPDATE holds SET status = ?, captured = ?, transaction_id = ?, updated_at = ?
+		 WHERE id = ? AND status = ?`,
+		status, captured, transactionID, time.Now().UTC().Format(time.RFC3339),
+		hold.id, holdAuthorized,
+	)
+	if err != nil {
+		logger.Error("Error updating hold: %s", err.Error())
+		return errInternalErrorOccurred
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return errInternalErrorOccurred
+	}
+
+	if rowsAffected == 0 {
+		return errHoldNotAuthorized
+	}
+
+	return ""
+}
+
+func decodeHoldRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	var req struct {
+		HoldID string `json:"hold_id"`
+		Amount string `json:"amount"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", "", false
+	}
+
+	if !util.ValidateTransactionID(req.HoldID) {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", "", false
+	}
+
+	return req.HoldID, req.Amount, true
+}
+
+func HoldAuthorize(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Recipient string `json:"recipient"`
+			Amount    string `json:"amount"`
+			Currency  string `json:"currency"`
+			Memo      string `json:"memo"`
+			ExpiresIn string `json:"expires_in"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Recipient == "" || len(req.Recipient) > 320 {
+			util.WriteJSONError(w, errRecipientNotFound)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		expiresIn := holdTTL
+		if req.ExpiresIn != "" {
+			hours, err := strconv.Atoi(req.ExpiresIn)
+			if err != nil || hours < 1 || hours > 720 {
+				util.WriteJSONError(w, errInvalidExpiry)
+				return
+			}
+
+			expiresIn = time.Duration(hours) * time.Hour
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		baseAmount, quoteErr := baseEquivalent(db, currency, amount)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
+
+		if policyErr := checkPolicy(user.Tier, policy.Activity{
+			Kind:   policy.Payment,
+			Amount: baseAmount,
+		}); policyErr != "" {
+			util.WriteJSONError(w, policyErr)
+			return
+		}
+
+		holdID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		recipientID, lookupErr := findUser(tx, req.Recipient, errRecipientNotFound)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		if recipientID == user.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errCannotPayOwnAccount)
+
+			return
+		}
+
+		now := time.Now().UTC()
+		expiresAt := now.Add(expiresIn).Format(time.RFC3339)
+
+		if _, err = tx.Exec(
+			`INSERT INTO holds
+			 (hold_id, kind, user_id, recipient_id, amount, currency, memo, status, expires_at, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			holdID, holdKindPayment, user.ID, recipientID, amount, currency, req.Memo, holdAuthorized,
+			expiresAt, now.Format(time.RFC3339), now.Format(time.RFC3339),
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		available, err := availableBalance(tx, user.ID, currency)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if available < 0 {
+			tx.Rollback()
+			util.WriteJSONError(w, errInsufficientFunds)
+
+			return
+		}
+
+		if err = notify(
+			tx, recipientID, "hold_authorized", holdID,
+			user.Username+" authorized a hold of "+formatAmount(amount, currency)+" for you.",
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.HoldAuthorized, user.ID, recipientID, holdID, map[string]interface{}{
+			"amount":     amount,
+			"currency":   currency,
+			"expires_at": expiresAt,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"hold_id":    holdID,
+			"amount":     amount,
+			"currency":   currency,
+			"available":  available,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+func HoldCapture(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		holdID, amountStr, ok := decodeHoldRequest(w, r)
+		if !ok {
+			return
+		}
+
+		transactionID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if err = expireHolds(tx); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		hold, holdErr := findHold(tx, holdID)
+		if holdErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, holdErr)
+
+			return
+		}
+
+		if hold.recipientID != user.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errOnlyRecipientCaptures)
+
+			return
+		}
+
+		amount := hold.amount
+		if amountStr != "" {
+			amount, err = money.Parse(amountStr)
+			if err != nil || amount <= 0 {
+				tx.Rollback()
+				util.WriteJSONError(w, errInvalidAmountValue)
+
+				return
+			}
+
+			if amount > hold.amount {
+				tx.Rollback()
+				util.WriteJSONError(w, errCaptureExceedsHold)
+
+				return
+			}
+		}
+
+		if closeErr := closeHold(tx, hold, holdCaptured, amount, transactionID); closeErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, closeErr)
+
+			return
+		}
+
+		charge, paymentErr := executePayment(
+			tx,
+			transactionID,
+			hold.userID,
+			hold.recipientID,
+			amount,
+			hold.currency,
+			hold.currency,
+		)
+		if paymentErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, paymentErr)
+
+			return
+		}
+
+		if err = notify(
+			tx, hold.userID, "hold_captured", transactionID,
+			user.Username+" captured "+formatAmount(amount, hold.currency)+" from your hold.",
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.HoldCaptured, user.ID, hold.userID, transactionID, map[string]interface{}{
+			"hold_id":  holdID,
+			"amount":   amount,
+			"currency": hold.currency,
+			"fee":      charge,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"hold_id":        holdID,
+			"transaction_id": transactionID,
+			"captured":       amount,
+			"released":       hold.amount - amount,
+			"fee":            charge,
+			"currency":       hold.currency,
+		})
+	}
+}
+
+func HoldVoid(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		holdID, _, ok := decodeHoldRequest(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if err = expireHolds(tx); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		hold, holdErr := findHold(tx, holdID)
+		if holdErr == "" && hold.userID != user.ID && hold.recipientID != user.ID {
+			holdErr = errHoldNotFound
+		}
+
+		if holdErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, holdErr)
+
+			return
+		}
+
+		if closeErr := closeHold(tx, hold, holdVoided, 0, nil); closeErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, closeErr)
+
+			return
+		}
+
+		counterparty := hold.recipientID
+		if user.ID == hold.recipientID {
+			counterparty = hold.userID
+		}
+
+		if err = notify(
+			tx, counterparty, "hold_voided", holdID,
+			user.Username+" voided a hold of "+formatAmount(hold.amount, hold.currency)+".",
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.HoldVoided, user.ID, counterparty, holdID, map[string]interface{}{
+			"amount":   hold.amount,
+			"currency": hold.currency,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"hold_id":  holdID,
+			"released": hold.amount,
+			"currency": hold.currency,
+		})
+	}
+}
+
+func HoldList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Status string `json:"status"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var status interface{}
+		switch req.Status {
+		case "":
+		case holdAuthorized, holdCaptured, holdVoided, holdExpired:
+			status = req.Status
+		default:
+			util.WriteJSONError(w, errInvalidHoldStatus)
+			return
+		}
+
+		if err := expireHolds(db); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT h.hold_id, h.kind, p.username, COALESCE(r.username, ''), h.amount, h.captured, h.currency,
+			 COALESCE(h.memo, ''), h.status, COALESCE(h.transaction_id, ''), h.expires_at, h.created_at
+			 FROM holds h
+			 JOIN users p ON p.id = h.user_id
+			 LEFT JOIN users r ON r.id = h.recipient_id
+			 WHERE (h.user_id = ?1 OR h.recipient_id = ?1) AND (?2 IS NULL OR h.status = ?2)
+			 ORDER BY h.created_at DESC, h.id DESC LIMIT 100`,
+			user.ID, status,
+		)
+		if err != nil {
+			logger.Error("Error querying holds: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+		defer rows.Close()
+
+		holds := []Hold{}
+		for rows.Next() {
+			var hold Hold
+			if err = rows.Scan(
+				&hold.HoldID, &hold.Kind, &hold.Payer, &hold.Recipient, &hold.Amount, &hold.Captured,
+				&hold.Currency, &hold.Memo, &hold.Status, &hold.TransactionID,
+				&hold.ExpiresAt, &hold.CreatedAt,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			holds = append(holds, hold)
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status": "ok",
+			"holds":  holds,
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/interest"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+var errReplayRange = errors.New("replay range must end before today and start on or before its end")
+
+type InterestSummary struct {
+	Accrued  int
+	Settled  int
+	Adjusted int
+}
+
+type interestAccount struct {
+	userID    int64
+	createdAt time.Time
+}
+
+func interestAccounts(db *sql.DB, product interest.Product, userID int64) ([]interestAccount, error) {
+	rows, err := db.Query(
+		`SELECT id, COALESCE(tier, ''), created_at FROM users
+		 WHERE (?1 = 0 OR id = ?1) AND status NOT IN (?2, ?3) ORDER BY id`,
+		userID, accountClosing, accountClosed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []interestAccount
+	for rows.Next() {
+		var account interestAccount
+		var tier, createdAt string
+
+		if err = rows.Scan(&account.userID, &tier, &createdAt); err != nil {
+			return nil, err
+		}
+
+		if !product.Covers(limitPolicy.TierName(tier)) {
+			continue
+		}
+
+		account.createdAt, _ = time.Parse(time.RFC3339, createdAt)
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func accrueInterest(
+	db *sql.DB,
+	userID int64,
+	product interest.Product,
+	day time.Time,
+	overwrite bool,
+) (bool, error) {
+	balance, err := ledger.BalanceAt(
+		db, ledger.WalletIn(userID, product.Currency),
+		day.AddDate(0, 0, 1).UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	query := `INSERT INTO interest_accruals
+		 (user_id, product, currency, day, balance, rate, accrued_micros, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(user_id, product, day) DO NOTHING`
+	if overwrite {
+		query = `INSERT INTO interest_accruals
+		 (user_id, product, currency, day, balance, rate, accrued_micros, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(user_id, product, day) DO UPDATE SET
+		 balance = excluded.balance, rate = excluded.rate,
+		 accrued_micros = excluded.accrued_micros, updated_at = excluded.updated_at
+		 WHERE balance != excluded.balance OR rate != excluded.rate
+		 OR accrued_micros != excluded.accrued_micros`
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := db.Exec(
+		query,
+		userID, product.Name, product.Currency, interest.Day(day), balance,
+		product.Rate(), interestPlan.Accrue(product, balance), now, now,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	return rowsAffected != 0, err
+}
+
+func accrualStart(db *sql.DB, userID int64, product interest.Product, today time.Time) (time.Time, error) {
+	location := today.Location()
+
+	var lastDay sql.NullString
+	if err := db.QueryRow(
+		"SELECT MAX(day) FROM interest_accruals WHERE user_id = ? AND product = ?",
+		userID, product.Name,
+	).Scan(&lastDay); err != nil {
+		return time.Time{}, err
+	}
+
+	if lastDay.Valid {
+		day, err := interest.ParseDay(lastDay.String, location)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return day.AddDate(0, 0, 1), nil
+	}
+
+	if start, ok := product.StartDate(); ok {
+		return time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, location), nil
+	}
+
+	return today.AddDate(0, 0, -1), nil
+}
+
+func settleInterest(
+	db *sql.DB,
+	userID int64,
+	product interest.Product,
+	period string,
+) (money.Amount, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	var micros int64
+	var posted money.Amount
+	var settlements int
+
+	if err = tx.QueryRow(
+		`SELECT COALESCE(SUM(accrued_micros), 0) FROM interest_accruals
+		 WHERE user_id = ? AND product = ? AND substr(day, 1, 7) = ?`,
+		userID, product.Name, period,
+	).Scan(&micros); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.QueryRow(
+		`SELECT COALESCE(SUM(amount), 0), COUNT(*) FROM interest_postings
+		 WHERE user_id = ? AND product = ? AND period = ?`,
+		userID, product.Name, period,
+	).Scan(&posted, &settlements); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	difference := interest.Payable(micros) - posted
+	if difference == 0 && settlements != 0 {
+		tx.Rollback()
+		return 0, nil
+	}
+
+	var transactionID interface{}
+	if difference != 0 {
+		tid, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		if err = ledger.Post(
+			tx, tid, "interest",
+			ledger.Debit(ledger.Interest(product.Currency), difference),
+			ledger.Credit(ledger.WalletIn(userID, product.Currency), difference),
+		); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		category, amount := "interest", difference
+		if difference < 0 {
+			category, amount = "interest_reversal", -difference
+		}
+
+		memo := "Interest for " + period + " (" + product.Name + ")"
+		if settlements != 0 {
+			memo = "Interest adjustment for " + period + " (" + product.Name + ")"
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err = tx.Exec(
+			`INSERT INTO transactions
+			 (transaction_id, user_id, category, amount, created_at, processed, memo, currency)
+			 VALUES (?, ?, ?, ?, ?, 1, ?, ?)`,
+			tid, userID, category, amount, now, memo, product.Currency,
+		); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		if err = notify(
+			tx, userID, "interest_posted", tid,
+			memo+": "+formatAmount(difference, product.Currency)+".",
+		); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		if err = recordAudit(tx, nil, audit.InterestSettled, 0, userID, tid, map[string]interface{}{
+			"product":  product.Name,
+			"period":   period,
+			"amount":   difference,
+			"currency": product.Currency,
+		}); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+
+		transactionID = tid
+	}
+
+	if _, err = tx.Exec(
+		`INSERT INTO interest_postings
+		 (user_id, product, currency, period, transaction_id, amount, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		userID, product.Name, product.Currency, period, transactionID, difference,
+		time.Now().UTC().Format(time.RFC3339),
+	); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	return difference, tx.Commit()
+}
+
+func pendingInterestPeriods(db *sql.DB, product interest.Product, before string) ([]int64, []string, error) {
+	rows, err := db.Query(
+		`SELECT DISTINCT a.user_id, substr(a.day, 1, 7) FROM interest_accruals a
+		 WHERE a.product = ?1 AND substr(a.day, 1, 7) < ?2 AND NOT EXISTS (
+			SELECT 1 FROM interest_postings p
+			WHERE p.user_id = a.user_id AND p.product = ?1 AND p.period = substr(a.day, 1, 7)
+		 )
+		 ORDER BY a.user_id, 2`,
+		product.Name, before,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var users []int64
+	var periods []string
+
+	for rows.Next() {
+		var userID int64
+		var period string
+
+		if err = rows.Scan(&userID, &period); err != nil {
+			return nil, nil, err
+		}
+
+		users = append(users, userID)
+		periods = append(periods, period)
+	}
+
+	return users, periods, rows.Err()
+}
+
+func startOfDay(t time.Time) time.Time {
+	local := t.In(businessCalendar.Location())
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+}
+
+func RunInterest(db *sql.DB) func(time.Time) error {
+	return func(now time.Time) error {
+		today := startOfDay(now)
+		currentPeriod := interest.Period(interest.Day(today))
+
+		for _, product := range interestPlan.Products() {
+			accounts, err := interestAccounts(db, product, 0)
+			if err != nil {
+				return err
+			}
+
+			for _, account := range accounts {
+				day, err := accrualStart(db, account.userID, product, today)
+				if err != nil {
+					return err
+				}
+
+				if opened := startOfDay(account.createdAt); day.Before(opened) {
+					day = opened
+				}
+
+				for ; day.Before(today); day = day.AddDate(0, 0, 1) {
+					if _, err = accrueInterest(db, account.userID, product, day, false); err != nil {
+						logger.Error("Error accruing interest for user %d: %s", account.userID, err.Error())
+						break
+					}
+				}
+			}
+
+			users, periods, err := pendingInterestPeriods(db, product, currentPeriod)
+			if err != nil {
+				return err
+			}
+
+			for i, userID := range users {
+				if _, err = settleInterest(db, userID, product, periods[i]); err != nil {
+					logger.Error(
+						"Error posting %s interest for user %d: %s",
+						periods[i], userID, err.Error(),
+					)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+func ReplayInterest(db *sql.DB, from, to string, username string) (InterestSummary, error) {
+	var summary InterestSummary
+	location := businessCalendar.Location()
+
+	start, err := interest.ParseDay(from, location)
+	if err != nil {
+		return summary, err
+	}
+
+	end, err := interest.ParseDay(to, location)
+	if err != nil {
+		return summary, err
+	}
+
+	today := startOfDay(time.Now())
+	if end.Before(start) || !end.Before(today) {
+		return summary, errReplayRange
+	}
+
+	var userID int64
+	if username != "" {
+		if err = db.QueryRow(
+			"SELECT id FROM users WHERE username = ?",
+			username,
+		).Scan(&userID); err == sql.ErrNoRows {
+			return summary, errors.New("user not found: " + username)
+		} else if err != nil {
+			return summary, err
+		}
+	}
+
+	currentPeriod := interest.Period(interest.Day(today))
+	for _, product := range interestPlan.Products() {
+		accounts, err := interestAccounts(db, product, userID)
+		if err != nil {
+			return summary, err
+		}
+
+		for _, account := range accounts {
+			periods := map[string]bool{}
+
+			day := start
+			if opened := startOfDay(account.createdAt); day.Before(opened) {
+				day = opened
+			}
+
+			for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+				changed, err := accrueInterest(db, account.userID, product, day, true)
+				if err != nil {
+					return summary, err
+				}
+
+				if changed {
+					summary.Accrued++
+				}
+
+				periods[interest.Period(interest.Day(day))] = true
+			}
+
+			for period := range periods {
+				if period >= currentPeriod {
+					continue
+				}
+
+				difference, err := settleInterest(db, account.userID, product, period)
+				if err != nil {
+					return summary, err
+				}
+
+				summary.Settled++
+				if difference != 0 {
+					summary.Adjusted++
+				}
+			}
+		}
+	}
+
+	return summary, nil
+}
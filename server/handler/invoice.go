@@ -0,0 +1,864 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/invoice"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/util"
+	"github.com/nthnn/ura/webhook"
+)
+
+var (
+	invoiceTermDays = 30
+
+	errMerchantRequired       = "Merchant account required"
+	errInvalidMerchantName    = "Merchant name must be 1 to 64 characters"
+	errCustomerNotFound       = "Customer not found"
+	errCannotInvoiceYourself  = "Cannot invoice your own account"
+	errDuplicateReference     = "Invoice reference already exists"
+	errDueDateInPast          = "Due date cannot be in the past"
+	errInvoiceNotFound        = "Invoice not found"
+	errInvoiceNotPaid         = "Invoice has not been paid"
+	errInvalidInvoiceStatus   = "Status must be unpaid, overdue, paid, cancelled or declined"
+	errInvoiceNotVisibleToYou = "Invoice is addressed to another user"
+)
+
+var invoiceStatuses = map[string]int{
+	"unpaid":    transactionPending,
+	"overdue":   transactionPending,
+	"paid":      transactionProcessed,
+	"cancelled": transactionCancelled,
+	"declined":  transactionDeclined,
+}
+
+const invoiceColumns = `i.id, i.invoice_id, i.reference, m.user_id, m.name, i.customer_id, c.username,
+	i.currency, i.subtotal, i.tax, i.total, i.memo, i.due_date, i.created_at, t.processed,
+	p.username, o.created_at,
+	(SELECT COALESCE(SUM(r.amount), 0) FROM transactions r
+	 WHERE r.related_transaction_id = i.invoice_id AND r.category IN ('refund_outgoing', 'reversal_outgoing'))`
+
+const invoiceJoins = `FROM invoices i
+	JOIN merchants m ON m.id = i.merchant_id
+	JOIN transactions t ON t.transaction_id = i.invoice_id AND t.category = 'payment_request'
+	LEFT JOIN users c ON c.id = i.customer_id
+	LEFT JOIN transactions o ON o.transaction_id = i.invoice_id AND o.category = 'outgoing'
+	LEFT JOIN users p ON p.id = o.user_id`
+
+type InvoiceItem struct {
+	Description string       `json:"description"`
+	Quantity    int64        `json:"quantity"`
+	UnitPrice   money.Amount `json:"unit_price"`
+	TaxRate     string       `json:"tax_rate"`
+	Amount      money.Amount `json:"amount"`
+	Tax         money.Amount `json:"tax"`
+}
+
+type Invoice struct {
+	InvoiceID string        `json:"invoice_id"`
+	Reference string        `json:"reference"`
+	Merchant  string        `json:"merchant"`
+	Customer  string        `json:"customer"`
+	Currency  string        `json:"currency"`
+	Subtotal  money.Amount  `json:"subtotal"`
+	Tax       money.Amount  `json:"tax"`
+	Total     money.Amount  `json:"total"`
+	Refunded  money.Amount  `json:"refunded"`
+	Memo      string        `json:"memo"`
+	DueDate   string        `json:"due_date"`
+	Status    string        `json:"status"`
+	Overdue   bool          `json:"overdue"`
+	PaidBy    string        `json:"paid_by"`
+	PaidAt    string        `json:"paid_at"`
+	CreatedAt string        `json:"created_at"`
+	Items     []InvoiceItem `json:"items,omitempty"`
+}
+
+type invoiceRecord struct {
+	Invoice
+
+	id             int64
+	merchantUserID int64
+	customerID     sql.NullInt64
+	processed      int
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func invoiceError(err error) string {
+	message := err.Error()
+	return strings.ToUpper(message[:1]) + message[1:]
+}
+
+func invoiceToday() string {
+	return invoice.FormatDueDate(startOfDay(time.Now()))
+}
+
+func invoiceStatus(processed int) string {
+	switch processed {
+	case transactionPending:
+		return "unpaid"
+	case transactionProcessed:
+		return "paid"
+	case transactionCancelled:
+		return "cancelled"
+	case transactionDeclined:
+		return "declined"
+	}
+
+	return "expired"
+}
+
+func scanInvoice(row rowScanner, today string) (invoiceRecord, error) {
+	var record invoiceRecord
+	var customer, memo, paidBy, paidAt sql.NullString
+
+	err := row.Scan(
+		&record.id, &record.InvoiceID, &record.Reference, &record.merchantUserID, &record.Merchant,
+		&record.customerID, &customer, &record.Currency, &record.Subtotal, &record.Tax,
+		&record.Total, &memo, &record.DueDate, &record.CreatedAt, &record.processed,
+		&paidBy, &paidAt, &record.Refunded,
+	)
+	if err != nil {
+		return record, err
+	}
+
+	record.Customer = customer.String
+	record.Memo = memo.String
+	record.PaidBy = paidBy.String
+	record.PaidAt = paidAt.String
+	record.Status = invoiceStatus(record.processed)
+	record.Overdue = record.processed == transactionPending && record.DueDate < today
+
+	return record, nil
+}
+
+func invoiceItems(q *sql.DB, invoiceID int64) ([]InvoiceItem, error) {
+	rows, err := q.Query(
+		`SELECT description, quantity, unit_price, tax_rate, amount, tax FROM invoice_items
+		 WHERE invoice_id = ? ORDER BY position`,
+		invoiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []InvoiceItem{}
+	for rows.Next() {
+		var item InvoiceItem
+		if err = rows.Scan(
+			&item.Description, &item.Quantity, &item.UnitPrice,
+			&item.TaxRate, &item.Amount, &item.Tax,
+		); err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func findMerchant(q queryer, userID int64) (int64, string, string) {
+	var merchantID int64
+	var name string
+
+	err := q.QueryRow(
+		"SELECT id, name FROM merchants WHERE user_id = ?",
+		userID,
+	).Scan(&merchantID, &name)
+
+	if err == sql.ErrNoRows {
+		return 0, "", errMerchantRequired
+	} else if err != nil {
+		logger.Error("Error querying merchant: %s", err.Error())
+		return 0, "", errInternalErrorOccurred
+	}
+
+	return merchantID, name, ""
+}
+
+func nextInvoiceReference(tx *sql.Tx, merchantID int64) (string, error) {
+	var next int64
+	if err := tx.QueryRow(
+		"SELECT next_reference FROM merchants WHERE id = ?",
+		merchantID,
+	).Scan(&next); err != nil {
+		return "", err
+	}
+
+	for ; ; next++ {
+		reference := fmt.Sprintf("INV-%06d", next)
+		taken, err := invoiceReferenceTaken(tx, merchantID, reference)
+		if err != nil {
+			return "", err
+		}
+
+		if !taken {
+			_, err = tx.Exec(
+				"UPDATE merchants SET next_reference = ? WHERE id = ?",
+				next+1, merchantID,
+			)
+
+			return reference, err
+		}
+	}
+}
+
+func invoiceReferenceTaken(tx *sql.Tx, merchantID int64, reference string) (bool, error) {
+	var count int
+	err := tx.QueryRow(
+		"SELECT COUNT(*) FROM invoices WHERE merchant_id = ? AND reference = ?",
+		merchantID, reference,
+	).Scan(&count)
+
+	return count != 0, err
+}
+
+func publishInvoicePaid(tx *sql.Tx, invoiceID, payer string) error {
+	var merchantUserID int64
+	var reference, currency string
+	var total money.Amount
+
+	err := tx.QueryRow(
+		`SELECT m.user_id, i.reference, i.total, i.currency FROM invoices i
+		 JOIN merchants m ON m.id = i.merchant_id
+		 WHERE i.invoice_id = ?`,
+		invoiceID,
+	).Scan(&merchantUserID, &reference, &total, &currency)
+
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return publish(tx, merchantUserID, webhook.InvoicePaid, map[string]interface{}{
+		"invoice_id": invoiceID,
+		"reference":  reference,
+		"amount":     total,
+		"currency":   currency,
+		"paid_by":    payer,
+	})
+}
+
+func MerchantRegister(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Name string `json:"name"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if name == "" || utf8.RuneCountInString(name) > 64 {
+			util.WriteJSONError(w, errInvalidMerchantName)
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err := db.Exec(
+			`INSERT INTO merchants (user_id, name, created_at, updated_at)
+			 VALUES (?, ?, ?, ?)
+			 ON CONFLICT(user_id) DO UPDATE SET name = excluded.name, updated_at = excluded.updated_at`,
+			user.ID, name, now, now,
+		); err != nil {
+			logger.Error("Error registering merchant: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{
+			"status": "ok",
+			"name":   name,
+		})
+	}
+}
+
+func InvoiceCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Customer  string `json:"customer"`
+			Currency  string `json:"currency"`
+			Reference string `json:"reference"`
+			DueDate   string `json:"due_date"`
+			Memo      string `json:"memo"`
+			Items     []struct {
+				Description string `json:"description"`
+				Quantity    int64  `json:"quantity"`
+				UnitPrice   string `json:"unit_price"`
+				TaxRate     string `json:"tax_rate"`
+			} `json:"items"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		items := make([]invoice.Item, len(req.Items))
+		for i, item := range req.Items {
+			unitPrice, err := money.Parse(item.UnitPrice)
+			if err != nil {
+				util.WriteJSONError(w, invoiceError(invoice.ErrInvalidUnitPrice))
+				return
+			}
+
+			items[i] = invoice.Item{
+				Description: item.Description,
+				Quantity:    item.Quantity,
+				UnitPrice:   unitPrice,
+				TaxRate:     item.TaxRate,
+			}
+		}
+
+		totals, err := invoice.Compute(items)
+		if err != nil {
+			util.WriteJSONError(w, invoiceError(err))
+			return
+		}
+
+		if req.Reference != "" && !invoice.ValidReference(req.Reference) {
+			util.WriteJSONError(w, invoiceError(invoice.ErrInvalidReference))
+			return
+		}
+
+		today := startOfDay(time.Now())
+		dueDate := today.AddDate(0, 0, invoiceTermDays)
+
+		if req.DueDate != "" {
+			dueDate, err = invoice.ParseDueDate(req.DueDate, businessCalendar.Location())
+			if err != nil {
+				util.WriteJSONError(w, invoiceError(err))
+				return
+			}
+
+			if dueDate.Before(today) {
+				util.WriteJSONError(w, errDueDateInPast)
+				return
+			}
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		if len(req.Customer) > 320 {
+			util.WriteJSONError(w, errCustomerNotFound)
+			return
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		baseAmount, quoteErr := baseEquivalent(db, currency, totals.Total)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
+
+		if policyErr := checkPolicy(user.Tier, policy.Activity{
+			Kind:   policy.Payment,
+			Amount: baseAmount,
+		}); policyErr != "" {
+			util.WriteJSONError(w, policyErr)
+			return
+		}
+
+		invoiceID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		merchantID, merchantName, merchantErr := findMerchant(tx, user.ID)
+		if merchantErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, merchantErr)
+
+			return
+		}
+
+		var customerID interface{}
+		if req.Customer != "" {
+			id, lookupErr := findUser(tx, req.Customer, errCustomerNotFound)
+			if lookupErr != "" {
+				tx.Rollback()
+				util.WriteJSONError(w, lookupErr)
+
+				return
+			}
+
+			if id == user.ID {
+				tx.Rollback()
+				util.WriteJSONError(w, errCannotInvoiceYourself)
+
+				return
+			}
+
+			customerID = id
+		}
+
+		reference := req.Reference
+		if reference == "" {
+			reference, err = nextInvoiceReference(tx, merchantID)
+		} else {
+			var taken bool
+			if taken, err = invoiceReferenceTaken(tx, merchantID, reference); err == nil && taken {
+				tx.Rollback()
+				util.WriteJSONError(w, errDuplicateReference)
+
+				return
+			}
+		}
+
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err = tx.Exec(
+			`INSERT INTO transactions
+			 (transaction_id, user_id, category, amount, created_at, processed, memo, target_user_id, currency)
+			 VALUES (?, ?, 'payment_request', ?, ?, 0, ?, ?, ?)`,
+			invoiceID, user.ID, totals.Total, now, "Invoice "+reference, customerID, currency,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		res, err := tx.Exec(
+			`INSERT INTO invoices
+			 (invoice_id, merchant_id, customer_id, reference, currency, subtotal, tax, total, memo, due_date, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			invoiceID, merchantID, customerID, reference, currency, totals.Subtotal,
+			totals.Tax, totals.Total, req.Memo, invoice.FormatDueDate(dueDate), now,
+		)
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		for position, line := range totals.Lines {
+			if _, err = tx.Exec(
+				`INSERT INTO invoice_items
+				 (invoice_id, position, description, quantity, unit_price, tax_rate, amount, tax)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				id, position, line.Description, line.Quantity, line.UnitPrice,
+				line.TaxRate, line.Amount, line.Tax,
+			); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+		}
+
+		if customer, ok := customerID.(int64); ok {
+			if err = notify(
+				tx, customer, "invoice_received", invoiceID,
+				merchantName+" sent you invoice "+reference+" for "+
+					formatAmount(totals.Total, currency)+", due "+invoice.FormatDueDate(dueDate)+".",
+			); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info("Invoice %s issued by merchant %d.", reference, merchantID)
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"invoice_id":     invoiceID,
+			"transaction_id": invoiceID,
+			"reference":      reference,
+			"subtotal":       totals.Subtotal,
+			"tax":            totals.Tax,
+			"total":          totals.Total,
+			"currency":       currency,
+			"due_date":       invoice.FormatDueDate(dueDate),
+		})
+	}
+}
+
+func InvoiceList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Status string `json:"status"`
+			Cursor string `json:"cursor"`
+			Limit  string `json:"limit"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		limit := transactionPageSize
+		if req.Limit != "" {
+			n, err := strconv.Atoi(req.Limit)
+			if err != nil || n < 1 || n > transactionMaxPageSize {
+				util.WriteJSONError(w, errInvalidPageSize)
+				return
+			}
+
+			limit = n
+		}
+
+		var cursorAt, cursorID interface{}
+		if req.Cursor != "" {
+			cursor, ok := parseTransactionCursor(req.Cursor)
+			if !ok {
+				util.WriteJSONError(w, errInvalidCursor)
+				return
+			}
+
+			cursorAt, cursorID = cursor.createdAt, cursor.id
+		}
+
+		today := invoiceToday()
+
+		var status, dueBefore interface{}
+		if req.Status != "" {
+			code, ok := invoiceStatuses[req.Status]
+			if !ok {
+				util.WriteJSONError(w, errInvalidInvoiceStatus)
+				return
+			}
+
+			status = code
+			if req.Status == "overdue" {
+				dueBefore = today
+			}
+		}
+
+		merchantID, _, merchantErr := findMerchant(db, user.ID)
+		if merchantErr != "" {
+			util.WriteJSONError(w, merchantErr)
+			return
+		}
+
+		rows, err := db.Query(
+			"SELECT "+invoiceColumns+" "+invoiceJoins+`
+			 WHERE i.merchant_id = ?1
+			 AND (?2 IS NULL OR t.processed = ?2)
+			 AND (?3 IS NULL OR i.due_date < ?3)
+			 AND (?4 IS NULL OR i.created_at < ?4 OR (i.created_at = ?4 AND i.id < ?5))
+			 ORDER BY i.created_at DESC, i.id DESC
+			 LIMIT ?6`,
+			merchantID, status, dueBefore, cursorAt, cursorID, limit+1,
+		)
+		if err != nil {
+			logger.Error("Error querying invoices: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+		defer rows.Close()
+
+		invoices := []Invoice{}
+		var last transactionCursor
+		hasMore := false
+
+		for rows.Next() {
+			record, err := scanInvoice(rows, today)
+			if err != nil {
+				logger.Error("Error scanning invoice: %s", err.Error())
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			if len(invoices) == limit {
+				hasMore = true
+				break
+			}
+
+			invoices = append(invoices, record.Invoice)
+			last = transactionCursor{createdAt: record.CreatedAt, id: record.id}
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		nextCursor := ""
+		if hasMore {
+			nextCursor = last.String()
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":      "ok",
+			"invoices":    invoices,
+			"has_more":    hasMore,
+			"next_cursor": nextCursor,
+		})
+	}
+}
+
+func InvoiceView(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			InvoiceID string `json:"invoice_id"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !util.ValidateTransactionID(req.InvoiceID) {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		record, err := scanInvoice(db.QueryRow(
+			"SELECT "+invoiceColumns+" "+invoiceJoins+" WHERE i.invoice_id = ?",
+			req.InvoiceID,
+		), invoiceToday())
+
+		if err == sql.ErrNoRows {
+			util.WriteJSONError(w, errInvoiceNotFound)
+			return
+		} else if err != nil {
+			logger.Error("Error querying invoice: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if record.merchantUserID != user.ID && record.customerID.Valid && record.customerID.Int64 != user.ID {
+			util.WriteJSONError(w, errInvoiceNotVisibleToYou)
+			return
+		}
+
+		if record.Items, err = invoiceItems(db, record.id); err != nil {
+			logger.Error("Error querying invoice items: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":  "ok",
+			"invoice": record.Invoice,
+		})
+	}
+}
+
+func InvoiceRefund(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r, accountClosing)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			InvoiceID string `json:"invoice_id"`
+			Amount    string `json:"amount"`
+			Memo      string `json:"memo"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !util.ValidateTransactionID(req.InvoiceID) {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		var amount money.Amount
+		if req.Amount != "" {
+			var err error
+			amount, err = money.Parse(req.Amount)
+			if err != nil || amount <= 0 {
+				util.WriteJSONError(w, errInvalidAmountValue)
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		merchantID, _, merchantErr := findMerchant(tx, user.ID)
+		if merchantErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, merchantErr)
+
+			return
+		}
+
+		var reference string
+		var processed int
+
+		err = tx.QueryRow(
+			`SELECT i.reference, t.processed FROM invoices i
+			 JOIN transactions t ON t.transaction_id = i.invoice_id AND t.category = 'payment_request'
+			 WHERE i.invoice_id = ? AND i.merchant_id = ?`,
+			req.InvoiceID, merchantID,
+		).Scan(&reference, &processed)
+
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			util.WriteJSONError(w, errInvoiceNotFound)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if processed != transactionProcessed {
+			tx.Rollback()
+			util.WriteJSONError(w, errInvoiceNotPaid)
+
+			return
+		}
+
+		memo := req.Memo
+		if memo == "" {
+			memo = "Refund for invoice " + reference
+		}
+
+		refund, refundErr := issueRefund(tx, user, req.InvoiceID, amount, memo)
+		if refundErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, refundErr)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.PaymentRefunded, user.ID, refund.payerID, refund.transactionID, map[string]interface{}{
+			"related_transaction_id": req.InvoiceID,
+			"amount":                 refund.amount,
+			"currency":               refund.currency,
+			"source":                 "invoice",
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": refund.transactionID,
+			"invoice_id":     req.InvoiceID,
+			"reference":      reference,
+			"amount":         refund.amount,
+			"currency":       refund.currency,
+			"refundable":     refund.refundable,
+		})
+	}
+}
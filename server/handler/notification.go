@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/util"
+)
+
+func notify(q execer, userID int64, kind, transactionID, message string) error {
+	_, err := q.Exec(
+		`INSERT INTO notifications (user_id, kind, transaction_id, message, created_at)
+		 VALUES (?, ?, ?, ?, ?)`,
+		userID, kind, transactionID, message,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+
+	return err
+}
+
+func NotificationList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT id, kind, transaction_id, message, created_at, read_at IS NOT NULL
+			 FROM notifications WHERE user_id = ?
+			 ORDER BY id DESC LIMIT 50`,
+			user.ID,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		notifications := []map[string]interface{}{}
+		for rows.Next() {
+			var id int64
+			var kind, message, createdAt string
+			var transactionID sql.NullString
+			var read bool
+
+			if err = rows.Scan(&id, &kind, &transactionID, &message, &createdAt, &read); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			notifications = append(notifications, map[string]interface{}{
+				"id":             id,
+				"kind":           kind,
+				"transaction_id": transactionID.String,
+				"message":        message,
+				"created_at":     createdAt,
+				"read":           read,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":        "ok",
+			"notifications": notifications,
+		})
+	}
+}
+
+func NotificationRead(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		if _, err := db.Exec(
+			"UPDATE notifications SET read_at = ? WHERE user_id = ? AND read_at IS NULL",
+			time.Now().UTC().Format(time.RFC3339), user.ID,
+		); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{"status": "ok"})
+	}
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/util"
+	"github.com/nthnn/ura/webhook"
+)
+
+var errRecipientNotFound = "Recipient not found"
+
+func executePayment(
+	tx *sql.Tx,
+	transactionID string,
+	payerID int64,
+	recipientID int64,
+	amount money.Amount,
+	currency string,
+	toCurrency string,
+) (money.Amount, string) {
+	if recipientID == payerID {
+		return 0, errCannotPayOwnAccount
+	}
+
+	if amount <= 0 {
+		return 0, errInvalidAmountValue
+	}
+
+	payerStatus, err := accountStatus(tx, payerID)
+	if err != nil {
+		logger.Error("Error querying payer status: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	if stateErr := accountStateError(payerStatus); stateErr != "" {
+		return 0, stateErr
+	}
+
+	recipientStatus, err := accountStatus(tx, recipientID)
+	if err != nil {
+		logger.Error("Error querying recipient status: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	if recipientStatus != accountActive {
+		return 0, errCounterpartyInactive
+	}
+
+	quote, quoteErr := quoteExchange(tx, currency, toCurrency, amount)
+	if quoteErr != "" {
+		return 0, quoteErr
+	}
+
+	baseAmount, quoteErr := baseEquivalent(tx, currency, amount)
+	if quoteErr != "" {
+		return 0, quoteErr
+	}
+
+	payerTier, err := userTier(tx, payerID)
+	if err != nil {
+		logger.Error("Error querying payer tier: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	if policyErr := checkPolicy(payerTier, policy.Activity{
+		Kind:   policy.Payment,
+		Amount: baseAmount,
+	}); policyErr != "" {
+		return 0, policyErr
+	}
+
+	recipientTier, err := userTier(tx, recipientID)
+	if err != nil {
+		logger.Error("Error querying recipient tier: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	received, err := receivedInWindow(tx, recipientID, recipientTier)
+	if err != nil {
+		logger.Error("Error querying received funds: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	if policyErr := checkPolicy(recipientTier, policy.Activity{
+		Kind:     policy.Receive,
+		Amount:   baseAmount,
+		Received: received,
+	}); policyErr != "" {
+		return 0, policyErr
+	}
+
+	err = ledger.Post(
+		tx, transactionID, "payment",
+		exchangePostings(
+			ledger.WalletIn(payerID, currency),
+			ledger.WalletIn(recipientID, toCurrency),
+			quote,
+		)...,
+	)
+
+	if err == ledger.ErrInsufficientFunds {
+		return 0, errInsufficientFunds
+	} else if err != nil {
+		logger.Error("Error posting payment: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	rate, spread := fxColumns(quote)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if _, err = tx.Exec(
+		`INSERT INTO transactions
+		 (transaction_id, user_id, category, amount, created_at, processed, currency, base_amount,
+		  fx_rate, fx_spread_bps, counter_amount, counter_currency)
+		 VALUES (?, ?, 'incoming', ?, ?, 1, ?, ?, ?, ?, ?, ?),
+		        (?, ?, 'outgoing', ?, ?, 1, ?, ?, ?, ?, ?, ?)`,
+		transactionID, recipientID, quote.Converted, now, toCurrency, baseAmount,
+		rate, spread, quote.Amount, currency,
+		transactionID, payerID, quote.Amount, now, currency, baseAmount,
+		rate, spread, quote.Converted, toCurrency,
+	); err != nil {
+		logger.Error("Error recording payment: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	charge, quoteErr := quoteFee(tx, fee.Payment, payerTier, currency, amount)
+	if quoteErr != "" {
+		return 0, quoteErr
+	}
+
+	err = recordFee(tx, payerID, transactionID, fee.Payment, currency, charge, transactionProcessed)
+	if err == ledger.ErrInsufficientFunds {
+		return 0, errInsufficientFunds
+	} else if err != nil {
+		logger.Error("Error charging payment fee: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	err = publish(tx, recipientID, webhook.PaymentReceived, map[string]interface{}{
+		"transaction_id": transactionID,
+		"amount":         quote.Converted,
+		"currency":       toCurrency,
+	})
+	if err == nil {
+		err = publish(tx, payerID, webhook.PaymentSent, map[string]interface{}{
+			"transaction_id": transactionID,
+			"amount":         quote.Amount,
+			"currency":       currency,
+			"fee":            charge,
+		})
+	}
+
+	if err != nil {
+		logger.Error("Error publishing payment events: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	return charge, ""
+}
+
+func findUser(tx *sql.Tx, lookup string, notFoundErr string) (int64, string) {
+	var userID int64
+	var status string
+
+	err := tx.QueryRow(
+		`SELECT id, status FROM users
+		 WHERE identifier = ?1 OR email = ?1 OR username = ?1
+		 ORDER BY CASE WHEN identifier = ?1 THEN 0 WHEN email = ?1 THEN 1 ELSE 2 END
+		 LIMIT 1`,
+		lookup,
+	).Scan(&userID, &status)
+
+	if err == sql.ErrNoRows {
+		return 0, notFoundErr
+	} else if err != nil {
+		logger.Error("Error querying user: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	if status == accountClosed {
+		return 0, notFoundErr
+	} else if status != accountActive {
+		return 0, errCounterpartyInactive
+	}
+
+	return userID, ""
+}
+
+func PaymentTransfer(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		payer, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Recipient  string `json:"recipient"`
+			Amount     string `json:"amount"`
+			Currency   string `json:"currency"`
+			ToCurrency string `json:"to_currency"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Recipient == "" || len(req.Recipient) > 320 {
+			util.WriteJSONError(w, errRecipientNotFound)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		transactionID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		recipientID, lookupErr := findUser(tx, req.Recipient, errRecipientNotFound)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		toCurrency := currency
+		if req.ToCurrency != "" {
+			toCurrency = normalizeCurrency(req.ToCurrency)
+		}
+
+		charge, paymentErr := executePayment(
+			tx,
+			transactionID,
+			payer.ID,
+			recipientID,
+			amount,
+			currency,
+			toCurrency,
+		)
+		if paymentErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, paymentErr)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.PaymentSent, payer.ID, recipientID, transactionID, map[string]interface{}{
+			"amount":      amount,
+			"currency":    currency,
+			"to_currency": toCurrency,
+			"fee":         charge,
+			"source":      "transfer",
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"fee":            charge,
+			"currency":       currency,
+		})
+	}
+}
@@ -0,0 +1,520 @@
+package handler
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	paymentRequestTTL = 7 * 24 * time.Hour
+
+	errInvalidMemo               = "Memo must be at most 140 characters"
+	errInvalidExpiry             = "Expiry must be between 1 and 720 hours"
+	errPaymentRequestExpired     = "Payment request has expired"
+	errPaymentRequestCancelled   = "Payment request was cancelled"
+	errPaymentRequestDeclined    = "Payment request was declined"
+	errPayerNotFound             = "Payer not found"
+	errOnlyRequesterCanCancel    = "Only the requester can cancel this payment request"
+	errPaymentRequestNotPending  = "Payment request is no longer pending"
+	errPaymentRequestNotForYou   = "Payment request is addressed to another user"
+	errPaymentRequestNotTargeted = "Payment request is not addressed to you"
+)
+
+func expirePaymentRequests(q execer) error {
+	_, err := q.Exec(
+		`UPDATE transactions SET processed = ?
+		 WHERE category = 'payment_request' AND processed = ?
+		 AND expires_at IS NOT NULL AND expires_at <= ?`,
+		transactionExpired,
+		transactionPending,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+
+	if err != nil {
+		logger.Error("Error expiring payment requests: %s", err.Error())
+	}
+	return err
+}
+
+func RunPaymentRequestExpiry(db *sql.DB) func(time.Time) error {
+	return func(time.Time) error {
+		return expirePaymentRequests(db)
+	}
+}
+
+func paymentRequestState(processed int, expiresAt sql.NullString) int {
+	if processed == transactionPending && expiresAt.Valid &&
+		expiresAt.String <= time.Now().UTC().Format(time.RFC3339) {
+		return transactionExpired
+	}
+
+	return processed
+}
+
+func paymentRequestStateError(processed int) string {
+	switch processed {
+	case transactionExpired:
+		return errPaymentRequestExpired
+	case transactionCancelled:
+		return errPaymentRequestCancelled
+	case transactionDeclined:
+		return errPaymentRequestDeclined
+	}
+
+	return errPaymentAlreadyProcessed
+}
+
+func payPaymentRequest(db *sql.DB, r *http.Request, payer *User, transactionID string, targetedOnly bool) string {
+	tx, err := db.Begin()
+	if err != nil {
+		return errInternalErrorOccurred
+	}
+
+	var amount money.Amount
+	var requesterID int64
+	var targetID sql.NullInt64
+	var processed int
+	var currency string
+	var expiresAt sql.NullString
+
+	err = tx.QueryRow(
+		`SELECT amount, user_id, target_user_id, processed, currency, expires_at FROM transactions
+		 WHERE transaction_id = ? AND category = 'payment_request'`,
+		transactionID,
+	).Scan(&amount, &requesterID, &targetID, &processed, &currency, &expiresAt)
+
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return errPaymentRequestNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	if targetID.Valid && targetID.Int64 != payer.ID {
+		tx.Rollback()
+		return errPaymentRequestNotForYou
+	}
+
+	if targetedOnly && !targetID.Valid {
+		tx.Rollback()
+		return errPaymentRequestNotTargeted
+	}
+
+	processed = paymentRequestState(processed, expiresAt)
+	if processed != transactionPending {
+		tx.Rollback()
+		return paymentRequestStateError(processed)
+	}
+
+	res, err := tx.Exec(
+		`UPDATE transactions
+		 SET processed = 1
+		 WHERE transaction_id = ? AND category = 'payment_request' AND processed = 0`,
+		transactionID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return errPaymentAlreadyProcessed
+	}
+
+	charge, paymentErr := executePayment(
+		tx,
+		transactionID,
+		payer.ID,
+		requesterID,
+		amount,
+		currency,
+		currency,
+	)
+	if paymentErr != "" {
+		tx.Rollback()
+		return paymentErr
+	}
+
+	if err = recordAudit(tx, r, audit.PaymentSent, payer.ID, requesterID, transactionID, map[string]interface{}{
+		"amount":   amount,
+		"currency": currency,
+		"fee":      charge,
+		"source":   "payment_request",
+	}); err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	if err = notify(
+		tx, requesterID, "payment_request_paid", transactionID,
+		payer.Username+" paid your payment request of "+formatAmount(amount, currency)+".",
+	); err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	if err = publishInvoicePaid(tx, transactionID, payer.Username); err != nil {
+		tx.Rollback()
+		return errInternalErrorOccurred
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errInternalErrorOccurred
+	}
+
+	return ""
+}
+
+func PaymentPreview(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var amount money.Amount
+		var requester, createdAt string
+		var payer, memo, expiresAt sql.NullString
+		var processed int
+		var currency string
+
+		err := db.QueryRow(
+			`SELECT t.amount, t.currency, u.username, p.username, t.memo, t.created_at, t.expires_at, t.processed
+			 FROM transactions t
+			 JOIN users u ON u.id = t.user_id
+			 LEFT JOIN users p ON p.id = t.target_user_id
+			 WHERE t.transaction_id = ? AND t.category = 'payment_request'`,
+			transactionID,
+		).Scan(&amount, &currency, &requester, &payer, &memo, &createdAt, &expiresAt, &processed)
+
+		if err == sql.ErrNoRows {
+			util.WriteJSONError(w, errPaymentRequestNotFound)
+			return
+		} else if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		processed = paymentRequestState(processed, expiresAt)
+		charge, quoteErr := quoteFee(db, fee.Payment, user.Tier, currency, amount)
+		if quoteErr != "" {
+			util.WriteJSONError(w, quoteErr)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"amount":         amount,
+			"fee":            charge,
+			"currency":       currency,
+			"requester":      requester,
+			"payer":          payer.String,
+			"memo":           memo.String,
+			"created_at":     createdAt,
+			"expires_at":     expiresAt.String,
+			"processed":      processed,
+		})
+	}
+}
+
+func PaymentCancel(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var requesterID int64
+		var processed int
+		var expiresAt sql.NullString
+
+		err = tx.QueryRow(
+			`SELECT user_id, processed, expires_at FROM transactions
+			 WHERE transaction_id = ? AND category = 'payment_request'`,
+			transactionID,
+		).Scan(&requesterID, &processed, &expiresAt)
+
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentRequestNotFound)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if requesterID != user.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errOnlyRequesterCanCancel)
+
+			return
+		}
+
+		processed = paymentRequestState(processed, expiresAt)
+		if processed != transactionPending {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentRequestNotPending)
+
+			return
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE transactions SET processed = ?
+			 WHERE transaction_id = ? AND category = 'payment_request' AND processed = ?`,
+			transactionCancelled, transactionID, transactionPending,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"processed":      transactionCancelled,
+		})
+	}
+}
+
+func PaymentInbox(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT t.transaction_id, t.amount, t.currency, u.username, t.memo, t.created_at, t.expires_at
+			 FROM transactions t
+			 JOIN users u ON u.id = t.user_id
+			 WHERE t.category = 'payment_request' AND t.target_user_id = ? AND t.processed = ?
+			 AND t.expires_at > ?
+			 ORDER BY t.created_at DESC`,
+			user.ID, transactionPending, time.Now().UTC().Format(time.RFC3339),
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		requests := []map[string]interface{}{}
+		for rows.Next() {
+			var transactionID, currency, requester, createdAt string
+			var amount money.Amount
+			var memo, expiresAt sql.NullString
+
+			if err = rows.Scan(&transactionID, &amount, &currency, &requester, &memo, &createdAt, &expiresAt); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			requests = append(requests, map[string]interface{}{
+				"transaction_id": transactionID,
+				"amount":         amount,
+				"currency":       currency,
+				"requester":      requester,
+				"memo":           memo.String,
+				"created_at":     createdAt,
+				"expires_at":     expiresAt.String,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"requests": requests,
+		})
+	}
+}
+
+func PaymentAccept(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if paymentErr := payPaymentRequest(db, r, user, transactionID, true); paymentErr != "" {
+			util.WriteJSONError(w, paymentErr)
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{
+			"status":         "ok",
+			"transaction_id": transactionID,
+		})
+	}
+}
+
+func PaymentDecline(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var amount money.Amount
+		var requesterID int64
+		var targetID sql.NullInt64
+		var processed int
+		var currency string
+		var expiresAt sql.NullString
+
+		err = tx.QueryRow(
+			`SELECT amount, user_id, target_user_id, processed, currency, expires_at FROM transactions
+			 WHERE transaction_id = ? AND category = 'payment_request'`,
+			transactionID,
+		).Scan(&amount, &requesterID, &targetID, &processed, &currency, &expiresAt)
+
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentRequestNotFound)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if !targetID.Valid || targetID.Int64 != user.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentRequestNotTargeted)
+
+			return
+		}
+
+		processed = paymentRequestState(processed, expiresAt)
+		if processed != transactionPending {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentRequestNotPending)
+
+			return
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE transactions SET processed = ?
+			 WHERE transaction_id = ? AND category = 'payment_request' AND processed = ?`,
+			transactionDeclined, transactionID, transactionPending,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = notify(
+			tx, requesterID, "payment_request_declined", transactionID,
+			user.Username+" declined your payment request of "+formatAmount(amount, currency)+".",
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"processed":      transactionDeclined,
+		})
+	}
+}
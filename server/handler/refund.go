@@ -0,0 +1,420 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+	"github.com/nthnn/ura/webhook"
+)
+
+var (
+	errPaymentNotFound        = "Payment not found"
+	errOnlyRecipientCanRefund = "Only the recipient can refund this payment"
+	errRefundExceedsPayment   = "Refund amount exceeds the refundable balance"
+	errPaymentFullyRefunded   = "Payment has already been fully refunded"
+	errPaymentAlreadyReversed = "Payment has already been reversed"
+	errReasonRequired         = "Reason is required"
+)
+
+type refundablePayment struct {
+	amount      money.Amount
+	refunded    money.Amount
+	payerID     int64
+	recipientID int64
+	currency    string
+	payerCur    string
+	reversed    bool
+}
+
+func findRefundablePayment(tx *sql.Tx, transactionID string) (refundablePayment, string) {
+	var payment refundablePayment
+	err := tx.QueryRow(
+		`SELECT i.amount, i.user_id, i.currency, o.user_id, o.currency FROM transactions i
+		 JOIN transactions o ON o.transaction_id = i.transaction_id AND o.category = 'outgoing'
+		 WHERE i.transaction_id = ? AND i.category = 'incoming' AND i.processed = 1`,
+		transactionID,
+	).Scan(&payment.amount, &payment.recipientID, &payment.currency, &payment.payerID, &payment.payerCur)
+
+	if err == sql.ErrNoRows {
+		return payment, errPaymentNotFound
+	} else if err != nil {
+		logger.Error("Error querying payment: %s", err.Error())
+		return payment, errInternalErrorOccurred
+	}
+
+	var reversals int
+	err = tx.QueryRow(
+		`SELECT COALESCE(SUM(CASE WHEN category = 'refund_outgoing' THEN amount ELSE 0 END), 0),
+		        COUNT(CASE WHEN category = 'reversal_outgoing' THEN 1 END)
+		 FROM transactions WHERE related_transaction_id = ?`,
+		transactionID,
+	).Scan(&payment.refunded, &reversals)
+
+	if err != nil {
+		logger.Error("Error querying refunds: %s", err.Error())
+		return payment, errInternalErrorOccurred
+	}
+
+	payment.reversed = reversals != 0
+	return payment, ""
+}
+
+func returnPayment(
+	tx *sql.Tx,
+	kind string,
+	originalID string,
+	payment refundablePayment,
+	amount money.Amount,
+	memo string,
+) (string, string) {
+	for _, userID := range []int64{payment.payerID, payment.recipientID} {
+		status, err := accountStatus(tx, userID)
+		if err != nil {
+			logger.Error("Error querying account status: %s", err.Error())
+			return "", errInternalErrorOccurred
+		}
+
+		if status == accountClosed {
+			return "", errCounterpartyInactive
+		}
+	}
+
+	transactionID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		return "", errInternalErrorOccurred
+	}
+
+	quote, quoteErr := quoteExchange(tx, payment.currency, payment.payerCur, amount)
+	if quoteErr != "" {
+		return "", quoteErr
+	}
+
+	err = ledger.Post(
+		tx, transactionID, kind,
+		exchangePostings(
+			ledger.WalletIn(payment.recipientID, payment.currency),
+			ledger.WalletIn(payment.payerID, payment.payerCur),
+			quote,
+		)...,
+	)
+
+	if err == ledger.ErrInsufficientFunds {
+		return "", errInsufficientFunds
+	} else if err != nil {
+		logger.Error("Error posting %s: %s", kind, err.Error())
+		return "", errInternalErrorOccurred
+	}
+
+	rate, spread := fxColumns(quote)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	if _, err = tx.Exec(
+		`INSERT INTO transactions
+		 (transaction_id, user_id, category, amount, created_at, processed, memo, related_transaction_id,
+		  currency, fx_rate, fx_spread_bps, counter_amount, counter_currency)
+		 VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?), (?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?)`,
+		transactionID, payment.recipientID, kind+"_outgoing", quote.Amount, now, memo, originalID,
+		payment.currency, rate, spread, quote.Converted, payment.payerCur,
+		transactionID, payment.payerID, kind+"_incoming", quote.Converted, now, memo, originalID,
+		payment.payerCur, rate, spread, quote.Amount, payment.currency,
+	); err != nil {
+		logger.Error("Error recording %s: %s", kind, err.Error())
+		return "", errInternalErrorOccurred
+	}
+
+	return transactionID, ""
+}
+
+type issuedRefund struct {
+	transactionID string
+	payerID       int64
+	amount        money.Amount
+	currency      string
+	refundable    money.Amount
+}
+
+func issueRefund(
+	tx *sql.Tx,
+	user *User,
+	transactionID string,
+	amount money.Amount,
+	memo string,
+) (issuedRefund, string) {
+	var refund issuedRefund
+
+	payment, lookupErr := findRefundablePayment(tx, transactionID)
+	if lookupErr != "" {
+		return refund, lookupErr
+	}
+
+	if payment.recipientID != user.ID {
+		return refund, errOnlyRecipientCanRefund
+	}
+
+	if payment.reversed {
+		return refund, errPaymentAlreadyReversed
+	}
+
+	refundable := payment.amount - payment.refunded
+	if refundable <= 0 {
+		return refund, errPaymentFullyRefunded
+	}
+
+	if amount == 0 {
+		amount = refundable
+	}
+
+	if amount > refundable {
+		return refund, errRefundExceedsPayment
+	}
+
+	refundID, refundErr := returnPayment(tx, "refund", transactionID, payment, amount, memo)
+	if refundErr != "" {
+		return refund, refundErr
+	}
+
+	if err := notify(
+		tx, payment.payerID, "payment_refunded", refundID,
+		user.Username+" refunded "+formatAmount(amount, payment.currency)+" to you.",
+	); err != nil {
+		return refund, errInternalErrorOccurred
+	}
+
+	event := map[string]interface{}{
+		"transaction_id":         refundID,
+		"related_transaction_id": transactionID,
+		"amount":                 amount,
+		"currency":               payment.currency,
+	}
+
+	if err := publish(tx, payment.recipientID, webhook.RefundIssued, event); err != nil {
+		return refund, errInternalErrorOccurred
+	}
+
+	if err := publish(tx, payment.payerID, webhook.RefundReceived, event); err != nil {
+		return refund, errInternalErrorOccurred
+	}
+
+	refund.transactionID = refundID
+	refund.payerID = payment.payerID
+	refund.amount = amount
+	refund.currency = payment.currency
+	refund.refundable = refundable - amount
+
+	return refund, ""
+}
+
+func PaymentRefund(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r, accountClosing)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			TransactionID string `json:"transaction_id"`
+			Amount        string `json:"amount"`
+			Memo          string `json:"memo"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !util.ValidateTransactionID(req.TransactionID) {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		var amount money.Amount
+		if req.Amount != "" {
+			var err error
+			amount, err = money.Parse(req.Amount)
+			if err != nil || amount <= 0 {
+				util.WriteJSONError(w, errInvalidAmountValue)
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		refund, refundErr := issueRefund(tx, user, req.TransactionID, amount, req.Memo)
+		if refundErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, refundErr)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.PaymentRefunded, user.ID, refund.payerID, refund.transactionID, map[string]interface{}{
+			"related_transaction_id": req.TransactionID,
+			"amount":                 refund.amount,
+			"currency":               refund.currency,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":                 "ok",
+			"transaction_id":         refund.transactionID,
+			"related_transaction_id": req.TransactionID,
+			"amount":                 refund.amount,
+			"currency":               refund.currency,
+			"refundable":             refund.refundable,
+		})
+	}
+}
+
+func AdminReversal(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		var req struct {
+			TransactionID string `json:"transaction_id"`
+			Reason        string `json:"reason"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !util.ValidateTransactionID(req.TransactionID) {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Reason == "" {
+			util.WriteJSONError(w, errReasonRequired)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Reason) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		payment, lookupErr := findRefundablePayment(tx, req.TransactionID)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		if payment.reversed {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentAlreadyReversed)
+
+			return
+		}
+
+		amount := payment.amount - payment.refunded
+		if amount <= 0 {
+			tx.Rollback()
+			util.WriteJSONError(w, errPaymentFullyRefunded)
+
+			return
+		}
+
+		reversalID, reversalErr := returnPayment(tx, "reversal", req.TransactionID, payment, amount, req.Reason)
+		if reversalErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, reversalErr)
+
+			return
+		}
+
+		for _, userID := range []int64{payment.payerID, payment.recipientID} {
+			if err = notify(
+				tx, userID, "payment_reversed", reversalID,
+				"A payment of "+formatAmount(amount, payment.currency)+" was reversed: "+req.Reason,
+			); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			if err = publish(tx, userID, webhook.PaymentReversed, map[string]interface{}{
+				"transaction_id":         reversalID,
+				"related_transaction_id": req.TransactionID,
+				"amount":                 amount,
+				"currency":               payment.currency,
+				"reason":                 req.Reason,
+			}); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+		}
+
+		if err = recordAudit(tx, r, audit.PaymentReversed, authorizedUser(r).ID, payment.recipientID, reversalID, map[string]interface{}{
+			"related_transaction_id": req.TransactionID,
+			"payer_id":               payment.payerID,
+			"amount":                 amount,
+			"currency":               payment.currency,
+			"reason":                 req.Reason,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":                 "ok",
+			"transaction_id":         reversalID,
+			"related_transaction_id": req.TransactionID,
+			"amount":                 amount,
+			"currency":               payment.currency,
+		})
+	}
+}
@@ -0,0 +1,520 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/util"
+	"github.com/nthnn/ura/webhook"
+)
+
+var (
+	errVoucherNotFound        = "Voucher not found"
+	errVoucherAlreadySettled  = "Voucher already settled"
+	errCannotSettleOwnVoucher = "Cannot settle own voucher"
+	errVoucherExpired         = "Voucher has expired"
+)
+
+type expiredVoucher struct {
+	transactionID string
+	userID        int64
+	category      string
+	amount        money.Amount
+}
+
+func placeVoucherHold(tx *sql.Tx, userID int64, transactionID string, amount money.Amount, expiresAt string) error {
+	holdID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = tx.Exec(
+		`INSERT INTO holds
+		 (hold_id, kind, user_id, amount, currency, status, transaction_id, expires_at, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		holdID, holdKindWithdraw, userID, amount, money.BaseCurrency, holdAuthorized,
+		transactionID, expiresAt, now, now,
+	)
+
+	return err
+}
+
+type withdrawVoucher struct {
+	transactionID string
+	amount        money.Amount
+	fee           money.Amount
+	expiresAt     string
+}
+
+func createWithdrawVoucher(tx *sql.Tx, user *User, amount money.Amount, waiveFee bool) (withdrawVoucher, string) {
+	voucher := withdrawVoucher{amount: amount}
+
+	transactionID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	received, err := receivedInWindow(tx, user.ID, user.Tier)
+	if err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	if policyErr := checkPolicy(user.Tier, policy.Activity{
+		Kind:     policy.Withdraw,
+		Amount:   amount,
+		Received: received,
+	}); policyErr != "" {
+		return voucher, policyErr
+	}
+
+	var charge money.Amount
+	if !waiveFee {
+		var quoteErr string
+		if charge, quoteErr = quoteFee(tx, fee.Withdraw, user.Tier, money.BaseCurrency, amount); quoteErr != "" {
+			return voucher, quoteErr
+		}
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(voucherTTL).Format(time.RFC3339)
+
+	if _, err = tx.Exec(
+		"INSERT INTO transactions (transaction_id, user_id, category, amount, created_at, processed, expires_at) "+
+			"VALUES (?, ?, 'withdraw', ?, ?, 0, ?)",
+		transactionID, user.ID, amount, now.Format(time.RFC3339), expiresAt,
+	); err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	if err = recordFee(
+		tx, user.ID, transactionID, fee.Withdraw,
+		money.BaseCurrency, charge, transactionPending,
+	); err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	if err = placeVoucherHold(tx, user.ID, transactionID, amount+charge, expiresAt); err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	available, err := availableBalance(tx, user.ID, money.BaseCurrency)
+	if err != nil {
+		return voucher, errInternalErrorOccurred
+	}
+
+	if available < 0 {
+		return voucher, errInsufficientFunds
+	}
+
+	voucher.transactionID = transactionID
+	voucher.fee = charge
+	voucher.expiresAt = expiresAt
+
+	return voucher, ""
+}
+
+func releaseVoucherHold(tx *sql.Tx, transactionID, status string) error {
+	_, err := tx.Exec(
+		`UPDATE holds
+		 SET status = ?1, captured = CASE WHEN ?1 = ?2 THEN amount ELSE 0 END, updated_at = ?3
+		 WHERE transaction_id = ?4 AND kind = ?5 AND status = ?6`,
+		status, holdCaptured, time.Now().UTC().Format(time.RFC3339),
+		transactionID, holdKindWithdraw, holdAuthorized,
+	)
+
+	return err
+}
+
+func dueVouchers(tx *sql.Tx, now string) ([]expiredVoucher, error) {
+	rows, err := tx.Query(
+		`SELECT transaction_id, user_id, category, amount FROM transactions
+		 WHERE category IN ('cashin', 'withdraw') AND processed = ?
+		 AND expires_at IS NOT NULL AND expires_at <= ?`,
+		transactionPending, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vouchers []expiredVoucher
+	for rows.Next() {
+		var voucher expiredVoucher
+		if err = rows.Scan(&voucher.transactionID, &voucher.userID, &voucher.category, &voucher.amount); err != nil {
+			return nil, err
+		}
+
+		vouchers = append(vouchers, voucher)
+	}
+
+	return vouchers, rows.Err()
+}
+
+func expireVouchers(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	vouchers, err := dueVouchers(tx, now)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, voucher := range vouchers {
+		if _, err = tx.Exec(
+			`UPDATE transactions SET processed = ?
+			 WHERE transaction_id = ? AND category = ? AND processed = ?`,
+			transactionExpired, voucher.transactionID, voucher.category, transactionPending,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = settleFees(tx, voucher.transactionID, transactionExpired); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = releaseVoucherHold(tx, voucher.transactionID, holdExpired); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		label := "withdraw"
+		if voucher.category == "cashin" {
+			label = "cash-in"
+		}
+
+		if err = notify(
+			tx, voucher.userID, "voucher_expired", voucher.transactionID,
+			"Your "+label+" voucher of "+formatAmount(voucher.amount, money.BaseCurrency)+" has expired.",
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = publish(tx, voucher.userID, webhook.VoucherExpired, map[string]interface{}{
+			"transaction_id": voucher.transactionID,
+			"category":       voucher.category,
+			"amount":         voucher.amount,
+			"currency":       money.BaseCurrency,
+		}); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err = recordAudit(tx, nil, audit.VoucherExpired, 0, voucher.userID, voucher.transactionID, map[string]interface{}{
+			"category": voucher.category,
+			"amount":   voucher.amount,
+		}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if len(vouchers) != 0 {
+		logger.Info("Expired %d unredeemed voucher(s).", len(vouchers))
+	}
+
+	return nil
+}
+
+func RunVoucherExpiry(db *sql.DB) func(time.Time) error {
+	return func(now time.Time) error {
+		return expireVouchers(db)
+	}
+}
+
+func decodeTransactionRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		TransactionID string `json:"transaction_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	if !util.ValidateTransactionID(req.TransactionID) {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	return req.TransactionID, true
+}
+
+func AgentVoucherLookup(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var category, username, createdAt string
+		var amount, charge money.Amount
+		var processed int
+		var settledBy, settledAt, expiresAt sql.NullString
+
+		if err := expireVouchers(db); err != nil {
+			logger.Error("Error expiring vouchers: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		err := db.QueryRow(
+			`SELECT t.category, t.amount, t.created_at, t.processed, u.username, a.username, t.settled_at, t.expires_at,
+			 (SELECT COALESCE(SUM(f.amount), 0) FROM transactions f
+			  WHERE f.category = 'fee' AND f.related_transaction_id = t.transaction_id)
+			 FROM transactions t
+			 JOIN users u ON u.id = t.user_id
+			 LEFT JOIN users a ON a.id = t.settled_by
+			 WHERE t.transaction_id = ? AND t.category IN ('cashin', 'withdraw')`,
+			transactionID,
+		).Scan(&category, &amount, &createdAt, &processed, &username, &settledBy, &settledAt, &expiresAt, &charge)
+
+		if err == sql.ErrNoRows {
+			util.WriteJSONError(w, errVoucherNotFound)
+			return
+		} else if err != nil {
+			logger.Error("Error querying voucher: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"category":       category,
+			"amount":         amount,
+			"fee":            charge,
+			"username":       username,
+			"created_at":     createdAt,
+			"processed":      processed,
+			"settled_by":     settledBy.String,
+			"settled_at":     settledAt.String,
+			"expires_at":     expiresAt.String,
+		})
+	}
+}
+
+func AgentVoucherConfirm(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return settleVoucher(db, true)
+}
+
+func AgentVoucherReject(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return settleVoucher(db, false)
+}
+
+func settleVoucher(db *sql.DB, confirm bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		agent := authorizedUser(r)
+
+		transactionID, ok := decodeTransactionRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := expireVouchers(db); err != nil {
+			logger.Error("Error expiring vouchers: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var userID int64
+		var category string
+		var amount money.Amount
+		var processed int
+
+		err = tx.QueryRow(
+			`SELECT user_id, category, amount, processed FROM transactions
+			 WHERE transaction_id = ? AND category IN ('cashin', 'withdraw')`,
+			transactionID,
+		).Scan(&userID, &category, &amount, &processed)
+
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			util.WriteJSONError(w, errVoucherNotFound)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if userID == agent.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errCannotSettleOwnVoucher)
+
+			return
+		}
+
+		if processed == transactionExpired {
+			tx.Rollback()
+			util.WriteJSONError(w, errVoucherExpired)
+
+			return
+		}
+
+		if confirm {
+			status, err := accountStatus(tx, userID)
+			if err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+
+			if status != accountActive && !(status == accountClosing && category == "withdraw") {
+				tx.Rollback()
+				util.WriteJSONError(w, errCounterpartyInactive)
+
+				return
+			}
+		}
+
+		status := transactionRejected
+		if confirm {
+			status = transactionProcessed
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		res, err := tx.Exec(
+			`UPDATE transactions
+			 SET processed = ?, settled_by = ?, settled_at = ?
+			 WHERE transaction_id = ? AND category = ? AND processed = ?`,
+			status, agent.ID, now, transactionID, category, transactionPending,
+		)
+
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if rowsAffected == 0 {
+			tx.Rollback()
+			util.WriteJSONError(w, errVoucherAlreadySettled)
+
+			return
+		}
+
+		if category == "withdraw" {
+			holdStatus := holdVoided
+			if confirm {
+				holdStatus = holdCaptured
+			}
+
+			if err = releaseVoucherHold(tx, transactionID, holdStatus); err != nil {
+				tx.Rollback()
+				util.WriteJSONError(w, errInternalErrorOccurred)
+
+				return
+			}
+		}
+
+		if confirm && category == "cashin" {
+			err = ledger.Post(
+				tx, transactionID, "cashin",
+				ledger.Debit(ledger.BankCash, amount),
+				ledger.Credit(ledger.Wallet(userID), amount),
+			)
+		} else if confirm && category == "withdraw" {
+			err = ledger.Post(
+				tx, transactionID, "withdraw",
+				ledger.Debit(ledger.Wallet(userID), amount),
+				ledger.Credit(ledger.BankCash, amount),
+			)
+		}
+
+		if err == nil {
+			err = settleFees(tx, transactionID, status)
+		}
+
+		if err == nil {
+			err = publish(tx, userID, webhook.VoucherSettled, map[string]interface{}{
+				"transaction_id": transactionID,
+				"category":       category,
+				"amount":         amount,
+				"currency":       money.BaseCurrency,
+				"processed":      status,
+			})
+		}
+
+		if err == nil {
+			err = recordAudit(tx, r, audit.VoucherSettled, agent.ID, userID, transactionID, map[string]interface{}{
+				"category":  category,
+				"amount":    amount,
+				"processed": status,
+			})
+		}
+
+		if err == ledger.ErrInsufficientFunds {
+			tx.Rollback()
+			util.WriteJSONError(w, errInsufficientFunds)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		logger.Info("Voucher %s (%s) settled by agent %d.", transactionID, category, agent.ID)
+		util.WriteJSON(w, map[string]interface{}{
+			"status":         "ok",
+			"transaction_id": transactionID,
+			"processed":      status,
+		})
+	}
+}
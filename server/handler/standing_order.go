@@ -0,0 +1,763 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/scheduler"
+	"github.com/nthnn/ura/util"
+)
+
+const (
+	standingOrderActive    = "active"
+	standingOrderPaused    = "paused"
+	standingOrderCompleted = "completed"
+	standingOrderCancelled = "cancelled"
+	standingOrderFailed    = "failed"
+
+	executionSucceeded = "succeeded"
+	executionRetrying  = "retrying"
+	executionFailed    = "failed"
+)
+
+var (
+	standingOrderMaxRetries = 3
+	standingOrderRetryDelay = time.Hour
+	standingOrderMaxOpen    = 20
+
+	errStandingOrderNotFound = "Standing order not found"
+	errStandingOrderNotOpen  = "Standing order is no longer active"
+	errInvalidRecurrence     = "Recurrence must be once, daily, weekly or monthly"
+	errInvalidStartDate      = "Start date must be a future RFC 3339 timestamp"
+	errInvalidEndDate        = "End date must be after the start date of a recurring order"
+	errInvalidStandingStatus = "Status must be active or paused"
+	errTooManyStandingOrders = "Too many open standing orders"
+)
+
+func nextOccurrence(start time.Time, recurrence string, n int, after time.Time) (time.Time, int, error) {
+	for {
+		next, err := scheduler.Occurrence(start, recurrence, n)
+		if err != nil || next.IsZero() || next.After(after) {
+			return next, n, err
+		}
+
+		n++
+	}
+}
+
+func decodeOrderRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		OrderID string `json:"order_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	if !util.ValidateTransactionID(req.OrderID) {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	return req.OrderID, true
+}
+
+func RunStandingOrders(db *sql.DB) func(time.Time) error {
+	return func(now time.Time) error {
+		rows, err := db.Query(
+			`SELECT id FROM standing_orders
+			 WHERE status = ? AND next_run_at <= ?
+			 ORDER BY next_run_at LIMIT 100`,
+			standingOrderActive, now.Format(time.RFC3339),
+		)
+		if err != nil {
+			return err
+		}
+
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err = executeStandingOrder(db, id, now); err != nil {
+				logger.Error("Error executing standing order %d: %s", id, err.Error())
+			}
+		}
+
+		return nil
+	}
+}
+
+func executeStandingOrder(db *sql.DB, id int64, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var orderID, recurrence, startAt, nextRunAt string
+	var userID, recipientID int64
+	var amount money.Amount
+	var endAt sql.NullString
+	var runCount, attempts int
+
+	err = tx.QueryRow(
+		`SELECT order_id, user_id, recipient_id, amount, recurrence, start_at, end_at, next_run_at, run_count, attempts
+		 FROM standing_orders WHERE id = ? AND status = ? AND next_run_at <= ?`,
+		id, standingOrderActive, now.Format(time.RFC3339),
+	).Scan(&orderID, &userID, &recipientID, &amount, &recurrence, &startAt, &endAt, &nextRunAt, &runCount, &attempts)
+
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	transactionID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("SAVEPOINT standing_order"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, paymentErr := executePayment(
+		tx, transactionID, userID, recipientID, amount,
+		money.BaseCurrency, money.BaseCurrency,
+	)
+	if paymentErr != "" {
+		if _, err = tx.Exec("ROLLBACK TO standing_order"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if _, err = tx.Exec("RELEASE standing_order"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if paymentErr == errInternalErrorOccurred {
+		tx.Rollback()
+		return errors.New(paymentErr)
+	}
+
+	status := standingOrderActive
+	executionStatus := executionSucceeded
+	next := ""
+
+	if paymentErr == errInsufficientFunds && attempts < standingOrderMaxRetries {
+		attempts++
+		executionStatus = executionRetrying
+		next = now.Add(standingOrderRetryDelay).Format(time.RFC3339)
+	} else {
+		if paymentErr != "" {
+			executionStatus = executionFailed
+		}
+
+		start, err := time.Parse(time.RFC3339, startAt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		nextTime, n, err := nextOccurrence(start, recurrence, runCount+1, now)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if endAt.Valid && !nextTime.IsZero() && nextTime.Format(time.RFC3339) > endAt.String {
+			nextTime = time.Time{}
+		}
+
+		if nextTime.IsZero() {
+			next = nextRunAt
+			status = standingOrderCompleted
+
+			if paymentErr != "" && recurrence == scheduler.Once {
+				status = standingOrderFailed
+			}
+		} else {
+			next = nextTime.Format(time.RFC3339)
+		}
+
+		runCount = n
+		attempts = 0
+	}
+
+	if _, err = tx.Exec(
+		`UPDATE standing_orders
+		 SET status = ?, next_run_at = ?, run_count = ?, attempts = ?, updated_at = ?
+		 WHERE id = ?`,
+		status, next, runCount, attempts, now.Format(time.RFC3339), id,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var executedTransaction interface{}
+	if paymentErr == "" {
+		executedTransaction = transactionID
+	}
+
+	if _, err = tx.Exec(
+		`INSERT INTO standing_order_executions
+		 (order_id, transaction_id, scheduled_for, executed_at, status, error)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		id, executedTransaction, nextRunAt, now.Format(time.RFC3339), executionStatus, paymentErr,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if executionStatus == executionFailed {
+		if err = notify(
+			tx, userID, "standing_order_failed", "",
+			"Standing order of "+formatAmount(amount, money.BaseCurrency)+" failed: "+paymentErr+".",
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if paymentErr == "" {
+		if err = recordAudit(tx, nil, audit.StandingOrderRun, 0, userID, transactionID, map[string]interface{}{
+			"order_id":     orderID,
+			"recipient_id": recipientID,
+			"amount":       amount,
+		}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func StandingOrderCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			Recipient  string `json:"recipient"`
+			Amount     string `json:"amount"`
+			Recurrence string `json:"recurrence"`
+			StartAt    string `json:"start_at"`
+			EndAt      string `json:"end_at"`
+			Memo       string `json:"memo"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Recipient == "" || len(req.Recipient) > 320 {
+			util.WriteJSONError(w, errRecipientNotFound)
+			return
+		}
+
+		amount, err := money.Parse(req.Amount)
+		if err != nil || amount <= 0 {
+			util.WriteJSONError(w, errInvalidAmountValue)
+			return
+		}
+
+		if policyErr := checkPolicy(user.Tier, policy.Activity{
+			Kind:   policy.Payment,
+			Amount: amount,
+		}); policyErr != "" {
+			util.WriteJSONError(w, policyErr)
+			return
+		}
+
+		if !scheduler.ValidRecurrence(req.Recurrence) {
+			util.WriteJSONError(w, errInvalidRecurrence)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		now := time.Now().UTC()
+		start := now
+		if req.StartAt != "" {
+			start, err = time.Parse(time.RFC3339, req.StartAt)
+			if err != nil || start.Before(now.Add(-time.Minute)) {
+				util.WriteJSONError(w, errInvalidStartDate)
+				return
+			}
+			start = start.UTC()
+		}
+
+		var endAt interface{}
+		if req.EndAt != "" {
+			end, err := time.Parse(time.RFC3339, req.EndAt)
+			if err != nil || !end.After(start) || req.Recurrence == scheduler.Once {
+				util.WriteJSONError(w, errInvalidEndDate)
+				return
+			}
+
+			endAt = end.UTC().Format(time.RFC3339)
+		}
+
+		orderID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var open int
+		if err = tx.QueryRow(
+			"SELECT COUNT(*) FROM standing_orders WHERE user_id = ? AND status IN (?, ?)",
+			user.ID, standingOrderActive, standingOrderPaused,
+		).Scan(&open); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if open >= standingOrderMaxOpen {
+			tx.Rollback()
+			util.WriteJSONError(w, errTooManyStandingOrders)
+
+			return
+		}
+
+		recipientID, lookupErr := findUser(tx, req.Recipient, errRecipientNotFound)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		if recipientID == user.ID {
+			tx.Rollback()
+			util.WriteJSONError(w, errCannotPayOwnAccount)
+
+			return
+		}
+
+		startAt := start.Format(time.RFC3339)
+		if _, err = tx.Exec(
+			`INSERT INTO standing_orders
+			 (order_id, user_id, recipient_id, amount, memo, recurrence, start_at, end_at,
+			  next_run_at, run_count, attempts, status, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, ?, ?, ?)`,
+			orderID, user.ID, recipientID, amount, req.Memo, req.Recurrence, startAt, endAt,
+			startAt, standingOrderActive, now.Format(time.RFC3339), now.Format(time.RFC3339),
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.StandingOrderSet, user.ID, recipientID, orderID, map[string]interface{}{
+			"amount":     amount,
+			"recurrence": req.Recurrence,
+			"start_at":   startAt,
+			"end_at":     endAt,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{
+			"status":      "ok",
+			"order_id":    orderID,
+			"next_run_at": startAt,
+		})
+	}
+}
+
+func StandingOrderList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT o.order_id, u.username, o.amount, o.memo, o.recurrence, o.start_at, o.end_at,
+			        o.next_run_at, o.run_count, o.attempts, o.status, o.created_at
+			 FROM standing_orders o
+			 JOIN users u ON u.id = o.recipient_id
+			 WHERE o.user_id = ?
+			 ORDER BY o.created_at DESC`,
+			user.ID,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		orders := []map[string]interface{}{}
+		for rows.Next() {
+			var orderID, recipient, recurrence, startAt, nextRunAt, status, createdAt string
+			var memo, endAt sql.NullString
+			var amount money.Amount
+			var runCount, attempts int
+
+			if err = rows.Scan(
+				&orderID, &recipient, &amount, &memo, &recurrence, &startAt, &endAt,
+				&nextRunAt, &runCount, &attempts, &status, &createdAt,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			orders = append(orders, map[string]interface{}{
+				"order_id":    orderID,
+				"recipient":   recipient,
+				"amount":      amount,
+				"memo":        memo.String,
+				"recurrence":  recurrence,
+				"start_at":    startAt,
+				"end_at":      endAt.String,
+				"next_run_at": nextRunAt,
+				"run_count":   runCount,
+				"attempts":    attempts,
+				"state":       status,
+				"created_at":  createdAt,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status": "ok",
+			"orders": orders,
+		})
+	}
+}
+
+func StandingOrderUpdate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticateActive(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			OrderID string `json:"order_id"`
+			Amount  string `json:"amount"`
+			Memo    string `json:"memo"`
+			EndAt   string `json:"end_at"`
+			State   string `json:"state"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !util.ValidateTransactionID(req.OrderID) {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.State != "" && req.State != standingOrderActive && req.State != standingOrderPaused {
+			util.WriteJSONError(w, errInvalidStandingStatus)
+			return
+		}
+
+		if utf8.RuneCountInString(req.Memo) > 140 {
+			util.WriteJSONError(w, errInvalidMemo)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var id int64
+		var amount money.Amount
+		var memo, endAt sql.NullString
+		var recurrence, startAt, status string
+
+		err = tx.QueryRow(
+			`SELECT id, amount, memo, end_at, recurrence, start_at, status FROM standing_orders
+			 WHERE order_id = ? AND user_id = ?`,
+			req.OrderID, user.ID,
+		).Scan(&id, &amount, &memo, &endAt, &recurrence, &startAt, &status)
+
+		if err == sql.ErrNoRows {
+			tx.Rollback()
+			util.WriteJSONError(w, errStandingOrderNotFound)
+
+			return
+		} else if err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if status != standingOrderActive && status != standingOrderPaused {
+			tx.Rollback()
+			util.WriteJSONError(w, errStandingOrderNotOpen)
+
+			return
+		}
+
+		if req.Amount != "" {
+			amount, err = money.Parse(req.Amount)
+			if err != nil || amount <= 0 {
+				tx.Rollback()
+				util.WriteJSONError(w, errInvalidAmountValue)
+
+				return
+			}
+
+			if policyErr := checkPolicy(user.Tier, policy.Activity{
+				Kind:   policy.Payment,
+				Amount: amount,
+			}); policyErr != "" {
+				tx.Rollback()
+				util.WriteJSONError(w, policyErr)
+
+				return
+			}
+		}
+
+		if req.Memo != "" {
+			memo = sql.NullString{String: req.Memo, Valid: true}
+		}
+
+		if req.EndAt != "" {
+			end, err := time.Parse(time.RFC3339, req.EndAt)
+			start, startErr := time.Parse(time.RFC3339, startAt)
+
+			if err != nil || startErr != nil || !end.After(start) || recurrence == scheduler.Once {
+				tx.Rollback()
+				util.WriteJSONError(w, errInvalidEndDate)
+
+				return
+			}
+
+			endAt = sql.NullString{String: end.UTC().Format(time.RFC3339), Valid: true}
+		}
+
+		if req.State != "" {
+			status = req.State
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE standing_orders
+			 SET amount = ?, memo = ?, end_at = ?, status = ?, updated_at = ?
+			 WHERE id = ?`,
+			amount, memo, endAt, status, time.Now().UTC().Format(time.RFC3339), id,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"order_id": req.OrderID,
+			"state":    status,
+		})
+	}
+}
+
+func StandingOrderCancel(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		orderID, ok := decodeOrderRequest(w, r)
+		if !ok {
+			return
+		}
+
+		res, err := db.Exec(
+			`UPDATE standing_orders SET status = ?, updated_at = ?
+			 WHERE order_id = ? AND user_id = ? AND status IN (?, ?)`,
+			standingOrderCancelled, time.Now().UTC().Format(time.RFC3339),
+			orderID, user.ID, standingOrderActive, standingOrderPaused,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if rowsAffected == 0 {
+			util.WriteJSONError(w, errStandingOrderNotOpen)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"order_id": orderID,
+			"state":    standingOrderCancelled,
+		})
+	}
+}
+
+func StandingOrderHistory(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		orderID, ok := decodeOrderRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var id int64
+		err := db.QueryRow(
+			"SELECT id FROM standing_orders WHERE order_id = ? AND user_id = ?",
+			orderID, user.ID,
+		).Scan(&id)
+
+		if err == sql.ErrNoRows {
+			util.WriteJSONError(w, errStandingOrderNotFound)
+			return
+		} else if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT transaction_id, scheduled_for, executed_at, status, error
+			 FROM standing_order_executions WHERE order_id = ?
+			 ORDER BY id DESC`,
+			id,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		executions := []map[string]interface{}{}
+		for rows.Next() {
+			var transactionID, failure sql.NullString
+			var scheduledFor, executedAt, status string
+
+			if err = rows.Scan(&transactionID, &scheduledFor, &executedAt, &status, &failure); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			executions = append(executions, map[string]interface{}{
+				"transaction_id": transactionID.String,
+				"scheduled_for":  scheduledFor,
+				"executed_at":    executedAt,
+				"result":         status,
+				"error":          failure.String,
+			})
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"order_id":   orderID,
+			"executions": executions,
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/ledger"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/statement"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	errInvalidStatementFormat = "Statement format must be pdf, csv, ofx or qif"
+	errStatementRangeTooLong  = "Statement range cannot exceed 366 days"
+)
+
+const statementMaxDays = 366
+
+type statementDetail struct {
+	category string
+	memo     string
+}
+
+func transactionLabel(category string) string {
+	label := strings.ReplaceAll(category, "_", " ")
+	if label == "" {
+		return label
+	}
+
+	r, size := utf8.DecodeRuneInString(label)
+	return string(unicode.ToUpper(r)) + label[size:]
+}
+
+func statementDetails(db *sql.DB, userID int64, currency, from, to string) (map[string]statementDetail, error) {
+	rows, err := db.Query(
+		`SELECT transaction_id, category, COALESCE(memo, '') FROM transactions
+		 WHERE user_id = ? AND currency = ? AND transaction_id IN (
+			SELECT transaction_id FROM journal_entries WHERE created_at >= ? AND created_at < ?
+		 )`,
+		userID, currency, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	details := map[string]statementDetail{}
+	for rows.Next() {
+		var transactionID string
+		var detail statementDetail
+
+		if err = rows.Scan(&transactionID, &detail.category, &detail.memo); err != nil {
+			return nil, err
+		}
+
+		details[transactionID] = detail
+	}
+
+	return details, rows.Err()
+}
+
+func AccountStatement(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			From     string `json:"from"`
+			To       string `json:"to"`
+			Format   string `json:"format"`
+			Currency string `json:"currency"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if req.Format == "" {
+			req.Format = string(statement.PDF)
+		}
+
+		format, err := statement.ParseFormat(req.Format)
+		if err != nil {
+			util.WriteJSONError(w, errInvalidStatementFormat)
+			return
+		}
+
+		now := time.Now().UTC()
+		if req.To == "" {
+			req.To = now.Format("2006-01-02")
+		}
+
+		if req.From == "" {
+			req.From = now.AddDate(0, 0, 1-now.Day()).Format("2006-01-02")
+		}
+
+		fromBound, fromOk := parseDateBound(req.From, false)
+		toBound, toOk := parseDateBound(req.To, true)
+		if !fromOk || !toOk {
+			util.WriteJSONError(w, errInvalidDateRange)
+			return
+		}
+
+		from, _ := time.Parse(time.RFC3339, fromBound.(string))
+		to, _ := time.Parse(time.RFC3339, toBound.(string))
+
+		if !from.Before(to) {
+			util.WriteJSONError(w, errInvalidDateRange)
+			return
+		}
+
+		if to.Sub(from) > statementMaxDays*24*time.Hour {
+			util.WriteJSONError(w, errStatementRangeTooLong)
+			return
+		}
+
+		currency := normalizeCurrency(req.Currency)
+		if _, lookupErr := exchangeEntry(db, currency); lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		opening, movements, err := ledger.Movements(
+			db, ledger.WalletIn(user.ID, currency),
+			fromBound.(string), toBound.(string),
+		)
+		if err != nil {
+			logger.Error("Error querying ledger movements: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		details, err := statementDetails(db, user.ID, currency, fromBound.(string), toBound.(string))
+		if err != nil {
+			logger.Error("Error querying statement details: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		result := statement.Statement{
+			Holder:      user.Username,
+			Account:     user.Identifier,
+			Currency:    currency,
+			From:        from,
+			To:          to,
+			Opening:     opening,
+			Closing:     opening,
+			GeneratedAt: now,
+		}
+
+		for _, movement := range movements {
+			date, _ := time.Parse(time.RFC3339, movement.CreatedAt)
+			line := statement.Line{
+				ID:            strconv.FormatInt(movement.EntryID, 10),
+				Date:          date,
+				TransactionID: movement.TransactionID,
+				Description:   transactionLabel(movement.Description),
+				Amount:        movement.Amount,
+			}
+
+			if detail, ok := details[movement.TransactionID]; ok {
+				line.Description = transactionLabel(detail.category)
+				line.Memo = detail.memo
+			}
+
+			result.Add(line)
+		}
+
+		var buf bytes.Buffer
+		if err = statement.Write(&buf, format, result); err != nil {
+			logger.Error("Error writing statement: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", `attachment; filename="`+format.Filename(result)+`"`)
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}
+}
@@ -0,0 +1,396 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/util"
+)
+
+const (
+	streamTicketTTL = 30 * time.Second
+	streamInterval  = 2 * time.Second
+	streamHeartbeat = 15 * time.Second
+)
+
+var (
+	streamTickets      = map[string]streamTicket{}
+	streamTicketsMutex sync.Mutex
+
+	streamShutdown     = make(chan struct{})
+	streamShutdownOnce sync.Once
+
+	errStreamingUnsupported = "Streaming is not supported"
+	errInvalidStreamTicket  = "Invalid or expired stream ticket"
+)
+
+type streamTicket struct {
+	userID       int64
+	sessionToken string
+	expiresAt    time.Time
+}
+
+type eventStream struct {
+	db           *sql.DB
+	w            http.ResponseWriter
+	flusher      http.Flusher
+	userID       int64
+	sessionToken string
+
+	balance          string
+	lastTransaction  int64
+	lastVersion      int64
+	lastNotification int64
+}
+
+func CloseEventStreams() {
+	streamShutdownOnce.Do(func() {
+		close(streamShutdown)
+	})
+}
+
+func consumeStreamTicket(ticket string) (streamTicket, bool) {
+	streamTicketsMutex.Lock()
+	defer streamTicketsMutex.Unlock()
+
+	issued, exists := streamTickets[ticket]
+	delete(streamTickets, ticket)
+
+	return issued, exists && time.Now().Before(issued.expiresAt)
+}
+
+func (s *eventStream) send(event string, data interface{}) bool {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return false
+	}
+
+	if _, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return false
+	}
+
+	s.flusher.Flush()
+	return true
+}
+
+func (s *eventStream) sessionActive() (bool, error) {
+	var expiresAt string
+	err := s.db.QueryRow(
+		"SELECT expires_at FROM sessions WHERE token = ? AND user_id = ?",
+		s.sessionToken, s.userID,
+	).Scan(&expiresAt)
+
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	return time.Now().Before(expiry), nil
+}
+
+func (s *eventStream) pushBalance() (bool, error) {
+	balances, err := walletBalances(s.db, s.userID)
+	if err != nil {
+		return false, err
+	}
+
+	available, err := availableBalances(s.db, s.userID, balances)
+	if err != nil {
+		return false, err
+	}
+
+	var balance money.Amount
+	if err = s.db.QueryRow(
+		"SELECT balance_ura FROM users WHERE id = ?",
+		s.userID,
+	).Scan(&balance); err != nil {
+		return false, err
+	}
+
+	availableUra, err := availableBalance(s.db, s.userID, money.BaseCurrency)
+	if err != nil {
+		return false, err
+	}
+
+	data := map[string]interface{}{
+		"balance_ura":   balance,
+		"available_ura": availableUra,
+		"balances":      balances,
+		"available":     available,
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	if string(payload) == s.balance {
+		return true, nil
+	}
+
+	s.balance = string(payload)
+	return s.send("balance", data), nil
+}
+
+func (s *eventStream) transactionState() (int64, int64, error) {
+	var lastID, lastVersion int64
+
+	err := s.db.QueryRow(
+		`SELECT COALESCE(MAX(id), 0), COALESCE(MAX(version), 0)
+		 FROM transactions WHERE user_id = ?`,
+		s.userID,
+	).Scan(&lastID, &lastVersion)
+
+	return lastID, lastVersion, err
+}
+
+func (s *eventStream) pushTransactions() (bool, error) {
+	lastID, lastVersion, err := s.transactionState()
+	if err != nil {
+		return false, err
+	}
+
+	if lastID == s.lastTransaction && lastVersion == s.lastVersion {
+		return true, nil
+	}
+
+	rows, err := s.db.Query(
+		"SELECT "+transactionColumns+` FROM transactions
+		 WHERE user_id = ? AND (id > ? OR version > ?) ORDER BY id`,
+		s.userID, s.lastTransaction, s.lastVersion,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	transactions := []map[string]interface{}{}
+	for rows.Next() {
+		_, transaction, err := scanTransaction(rows)
+		if err != nil {
+			return false, err
+		}
+
+		transactions = append(transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	s.lastTransaction, s.lastVersion = lastID, lastVersion
+	if len(transactions) == 0 {
+		return true, nil
+	}
+
+	return s.send("transactions", map[string]interface{}{
+		"transactions": transactions,
+	}), nil
+}
+
+func (s *eventStream) pushNotifications() (bool, error) {
+	rows, err := s.db.Query(
+		`SELECT id, kind, transaction_id, message, created_at FROM notifications
+		 WHERE user_id = ? AND id > ? ORDER BY id`,
+		s.userID, s.lastNotification,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var kind, message, createdAt string
+		var transactionID sql.NullString
+
+		if err = rows.Scan(&id, &kind, &transactionID, &message, &createdAt); err != nil {
+			return false, err
+		}
+
+		s.lastNotification = id
+		if !s.send("notification", map[string]interface{}{
+			"id":             id,
+			"kind":           kind,
+			"transaction_id": transactionID.String,
+			"message":        message,
+			"created_at":     createdAt,
+		}) {
+			return false, nil
+		}
+	}
+
+	return true, rows.Err()
+}
+
+func (s *eventStream) poll() bool {
+	active, err := s.sessionActive()
+	if err != nil {
+		logger.Error("Error checking stream session: %s", err.Error())
+		return false
+	}
+
+	if !active {
+		s.send("session", map[string]bool{"expired": true})
+		return false
+	}
+
+	for _, push := range []func() (bool, error){
+		s.pushBalance,
+		s.pushTransactions,
+		s.pushNotifications,
+	} {
+		ok, err := push()
+		if err != nil {
+			logger.Error("Error streaming events to user %d: %s", s.userID, err.Error())
+			return false
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func EventStreamTicket(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		ticket, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		now := time.Now()
+
+		streamTicketsMutex.Lock()
+		for key, issued := range streamTickets {
+			if now.After(issued.expiresAt) {
+				delete(streamTickets, key)
+			}
+		}
+
+		streamTickets[ticket] = streamTicket{
+			userID:       user.ID,
+			sessionToken: r.Header.Get("X-Session-Token"),
+			expiresAt:    now.Add(streamTicketTTL),
+		}
+		streamTicketsMutex.Unlock()
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"ticket":     ticket,
+			"expires_in": int(streamTicketTTL.Seconds()),
+		})
+	}
+}
+
+func EventStream(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		ticket := r.URL.Query().Get("ticket")
+		if !util.ValidateTransactionID(ticket) {
+			util.WriteJSONError(w, errInvalidStreamTicket)
+			return
+		}
+
+		issued, ok := consumeStreamTicket(ticket)
+		if !ok {
+			util.WriteJSONError(w, errInvalidStreamTicket)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			util.WriteJSONError(w, errStreamingUnsupported)
+			return
+		}
+
+		stream := &eventStream{
+			db:           db,
+			w:            w,
+			flusher:      flusher,
+			userID:       issued.userID,
+			sessionToken: issued.sessionToken,
+		}
+
+		var err error
+		stream.lastTransaction, stream.lastVersion, err = stream.transactionState()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if err = db.QueryRow(
+			"SELECT COALESCE(MAX(id), 0) FROM notifications WHERE user_id = ?",
+			issued.userID,
+		).Scan(&stream.lastNotification); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if !stream.poll() {
+			return
+		}
+
+		ticker := time.NewTicker(streamInterval)
+		defer ticker.Stop()
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-streamShutdown:
+				return
+
+			case <-heartbeat.C:
+				if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+
+			case <-ticker.C:
+				if !stream.poll() {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package handler
+
+import "database/sql"
+
+const (
+	transactionPending   = 0
+	transactionProcessed = 1
+	transactionRejected  = 2
+	transactionCancelled = 3
+	transactionExpired   = 4
+	transactionDeclined  = 5
+)
+
+var transactionStatuses = map[string]int{
+	"pending":   transactionPending,
+	"processed": transactionProcessed,
+	"rejected":  transactionRejected,
+	"cancelled": transactionCancelled,
+	"expired":   transactionExpired,
+	"declined":  transactionDeclined,
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
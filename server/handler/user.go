@@ -1,13 +1,78 @@
 package handler
 
-import "time"
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/money"
+	"github.com/nthnn/ura/rbac"
+)
 
 type User struct {
-	ID           int64     `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	Identifier   string    `json:"identifier"`
-	SecurityCode string    `json:"-"`
-	BalanceUra   float64   `json:"balance_ura"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID           int64        `json:"id"`
+	Username     string       `json:"username"`
+	Email        string       `json:"email"`
+	Identifier   string       `json:"identifier"`
+	SecurityCode string       `json:"-"`
+	BalanceUra   money.Amount `json:"balance_ura"`
+	AvailableUra money.Amount `json:"available_ura"`
+	Role         string       `json:"role"`
+	Tier         string       `json:"tier"`
+	Status       string       `json:"status"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+func AssignRole(db *sql.DB, username, role string) error {
+	if !rbac.ValidRole(role) {
+		return errors.New("unknown role: " + role)
+	}
+
+	return assignAccountField(db, "role", audit.RoleAssigned, username, role)
+}
+
+func AssignTier(db *sql.DB, username, tier string) error {
+	if !limitPolicy.HasTier(tier) {
+		return errors.New("unknown tier: " + tier)
+	}
+
+	return assignAccountField(db, "tier", audit.TierAssigned, username, tier)
+}
+
+func assignAccountField(db *sql.DB, column, action, username, value string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var userID int64
+	var previous string
+
+	err = tx.QueryRow(
+		"SELECT id, COALESCE("+column+", '') FROM users WHERE username = ?",
+		username,
+	).Scan(&userID, &previous)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return errors.New("user not found: " + username)
+	} else if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE users SET "+column+" = ? WHERE id = ?", value, userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = recordAudit(tx, nil, action, 0, userID, "", map[string]string{
+		"from": previous,
+		"to":   value,
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,730 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/util"
+	"github.com/nthnn/ura/webhook"
+)
+
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+
+	maxWebhookEndpoints = 10
+	webhookBatchSize    = 25
+)
+
+var (
+	webhookClient = webhook.NewClient(10 * time.Second)
+
+	errWebhookNotFound     = "Webhook endpoint not found"
+	errDeliveryNotFound    = "Webhook delivery not found"
+	errInvalidWebhookURL   = "Webhook URL must be an absolute http or https URL"
+	errWebhookHostRejected = "Webhook URL must resolve to a public address"
+	errInvalidWebhookEvent = "Unknown webhook event"
+	errTooManyWebhooks     = "At most 10 webhook endpoints are allowed"
+)
+
+type WebhookEndpoint struct {
+	EndpointID string   `json:"endpoint_id"`
+	URL        string   `json:"url"`
+	Events     []string `json:"events"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	DeliveryID    string           `json:"delivery_id"`
+	EventID       string           `json:"event_id"`
+	Event         string           `json:"event"`
+	Status        string           `json:"status"`
+	Attempts      int              `json:"attempts"`
+	ResponseCode  int              `json:"response_code"`
+	LastError     string           `json:"last_error"`
+	NextAttemptAt string           `json:"next_attempt_at"`
+	CreatedAt     string           `json:"created_at"`
+	DeliveredAt   string           `json:"delivered_at"`
+	Payload       json.RawMessage  `json:"payload,omitempty"`
+	Log           []WebhookAttempt `json:"log,omitempty"`
+}
+
+type WebhookAttempt struct {
+	Attempt      int    `json:"attempt"`
+	ResponseCode int    `json:"response_code"`
+	Error        string `json:"error"`
+	DurationMs   int64  `json:"duration_ms"`
+	AttemptedAt  string `json:"attempted_at"`
+}
+
+type dueDelivery struct {
+	id         int64
+	deliveryID string
+	event      string
+	payload    string
+	attempts   int
+	url        string
+	secret     string
+}
+
+func publish(q execer, userID int64, event string, data map[string]interface{}) error {
+	eventID, err := util.GenerateRandomIdentifier(256)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":         eventID,
+		"type":       event,
+		"created_at": now,
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(
+		`INSERT INTO webhook_deliveries
+		 (delivery_id, event_id, endpoint_id, event, payload, status, next_attempt_at, created_at)
+		 SELECT lower(hex(randomblob(32))), ?1, id, ?2, ?3, ?4, ?5, ?5 FROM webhook_endpoints
+		 WHERE user_id = ?6 AND active = 1
+		 AND (events = '*' OR instr(',' || events || ',', ',' || ?2 || ',') > 0)`,
+		eventID, event, string(payload), deliveryPending, now, userID,
+	)
+
+	return err
+}
+
+func dueDeliveries(db *sql.DB, now string) ([]dueDelivery, error) {
+	rows, err := db.Query(
+		`SELECT d.id, d.delivery_id, d.event, d.payload, d.attempts, e.url, e.secret
+		 FROM webhook_deliveries d
+		 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+		 WHERE d.status = ? AND d.next_attempt_at <= ? AND e.active = 1
+		 ORDER BY d.next_attempt_at, d.id LIMIT ?`,
+		deliveryPending, now, webhookBatchSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []dueDelivery
+	for rows.Next() {
+		var delivery dueDelivery
+		if err = rows.Scan(
+			&delivery.id, &delivery.deliveryID, &delivery.event, &delivery.payload,
+			&delivery.attempts, &delivery.url, &delivery.secret,
+		); err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func recordAttempt(db *sql.DB, delivery dueDelivery, result webhook.Result) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	attempt := delivery.attempts + 1
+
+	var lastError, nextAttemptAt, deliveredAt interface{}
+	status := deliveryDelivered
+
+	if result.OK() {
+		deliveredAt = now.Format(time.RFC3339)
+	} else {
+		lastError = result.Err.Error()
+		status = deliveryFailed
+
+		if attempt < webhook.MaxAttempts {
+			status = deliveryPending
+			nextAttemptAt = now.Add(webhook.Backoff(attempt)).Format(time.RFC3339)
+		}
+	}
+
+	var responseCode interface{}
+	if result.StatusCode != 0 {
+		responseCode = result.StatusCode
+	}
+
+	if _, err = tx.Exec(
+		`INSERT INTO webhook_attempts (delivery_id, attempt, response_code, error, duration_ms, attempted_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		delivery.id, attempt, responseCode, lastError,
+		result.Duration.Milliseconds(), now.Format(time.RFC3339),
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(
+		`UPDATE webhook_deliveries
+		 SET status = ?, attempts = ?, next_attempt_at = ?, response_code = ?, last_error = ?, delivered_at = ?
+		 WHERE id = ?`,
+		status, attempt, nextAttemptAt, responseCode, lastError, deliveredAt, delivery.id,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func RunWebhooks(db *sql.DB) func(time.Time) error {
+	return func(now time.Time) error {
+		deliveries, err := dueDeliveries(db, now.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, delivery := range deliveries {
+			result := webhook.Deliver(
+				webhookClient, delivery.url, delivery.secret,
+				delivery.deliveryID, delivery.event, []byte(delivery.payload),
+			)
+
+			if !result.OK() {
+				failed++
+			}
+
+			if err = recordAttempt(db, delivery, result); err != nil {
+				logger.Error("Error recording webhook delivery %s: %s", delivery.deliveryID, err.Error())
+			}
+		}
+
+		if failed != 0 {
+			logger.Info("%d of %d webhook delivery attempt(s) failed.", failed, len(deliveries))
+		}
+
+		return nil
+	}
+}
+
+func findWebhookEndpoint(q queryer, userID int64, endpointID string) (int64, string) {
+	var id int64
+	err := q.QueryRow(
+		"SELECT id FROM webhook_endpoints WHERE endpoint_id = ? AND user_id = ? AND active = 1",
+		endpointID, userID,
+	).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		return 0, errWebhookNotFound
+	} else if err != nil {
+		logger.Error("Error querying webhook endpoint: %s", err.Error())
+		return 0, errInternalErrorOccurred
+	}
+
+	return id, ""
+}
+
+func decodeEndpointRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		EndpointID string `json:"endpoint_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	if !util.ValidateTransactionID(req.EndpointID) {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	return req.EndpointID, true
+}
+
+func WebhookCreate(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		if !webhook.ValidURL(req.URL) {
+			util.WriteJSONError(w, errInvalidWebhookURL)
+			return
+		}
+
+		if err := webhook.CheckHost(req.URL); err != nil {
+			util.WriteJSONError(w, errWebhookHostRejected)
+			return
+		}
+
+		events := webhook.AllEvents
+		if len(req.Events) != 0 {
+			seen := map[string]bool{}
+			var selected []string
+
+			for _, event := range req.Events {
+				if event == webhook.AllEvents {
+					selected = nil
+					break
+				}
+
+				if !webhook.ValidEvent(event) {
+					util.WriteJSONError(w, errInvalidWebhookEvent)
+					return
+				}
+
+				if !seen[event] {
+					seen[event] = true
+					selected = append(selected, event)
+				}
+			}
+
+			if selected != nil {
+				events = strings.Join(selected, ",")
+			}
+		}
+
+		endpointID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		secret, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		var count int
+		if err = tx.QueryRow(
+			"SELECT COUNT(*) FROM webhook_endpoints WHERE user_id = ? AND active = 1",
+			user.ID,
+		).Scan(&count); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if count >= maxWebhookEndpoints {
+			tx.Rollback()
+			util.WriteJSONError(w, errTooManyWebhooks)
+
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err = tx.Exec(
+			`INSERT INTO webhook_endpoints (endpoint_id, user_id, url, secret, events, created_at, updated_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			endpointID, user.ID, req.URL, secret, events, now, now,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.WebhookCreated, user.ID, user.ID, endpointID, map[string]interface{}{
+			"url":    req.URL,
+			"events": events,
+		}); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":      "ok",
+			"endpoint_id": endpointID,
+			"url":         req.URL,
+			"events":      strings.Split(events, ","),
+			"secret":      secret,
+		})
+	}
+}
+
+func WebhookList(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT endpoint_id, url, events, created_at FROM webhook_endpoints
+			 WHERE user_id = ? AND active = 1 ORDER BY id`,
+			user.ID,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		endpoints := []WebhookEndpoint{}
+		for rows.Next() {
+			var endpoint WebhookEndpoint
+			var events string
+
+			if err = rows.Scan(&endpoint.EndpointID, &endpoint.URL, &events, &endpoint.CreatedAt); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			endpoint.Events = strings.Split(events, ",")
+			endpoints = append(endpoints, endpoint)
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":    "ok",
+			"endpoints": endpoints,
+			"events":    webhook.Events,
+		})
+	}
+}
+
+func WebhookDelete(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		endpointID, ok := decodeEndpointRequest(w, r)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		id, lookupErr := findWebhookEndpoint(tx, user.ID, endpointID)
+		if lookupErr != "" {
+			tx.Rollback()
+			util.WriteJSONError(w, lookupErr)
+
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		if _, err = tx.Exec(
+			"UPDATE webhook_endpoints SET active = 0, updated_at = ? WHERE id = ?",
+			now, id,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if _, err = tx.Exec(
+			`UPDATE webhook_deliveries SET status = ?, next_attempt_at = NULL, last_error = 'Endpoint removed'
+			 WHERE endpoint_id = ? AND status = ?`,
+			deliveryFailed, id, deliveryPending,
+		); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = recordAudit(tx, r, audit.WebhookDeleted, user.ID, user.ID, endpointID, nil); err != nil {
+			tx.Rollback()
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{"status": "ok"})
+	}
+}
+
+func WebhookDeliveries(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		endpointID, ok := decodeEndpointRequest(w, r)
+		if !ok {
+			return
+		}
+
+		id, lookupErr := findWebhookEndpoint(db, user.ID, endpointID)
+		if lookupErr != "" {
+			util.WriteJSONError(w, lookupErr)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT delivery_id, event_id, event, status, attempts, COALESCE(response_code, 0),
+			 COALESCE(last_error, ''), COALESCE(next_attempt_at, ''), created_at, COALESCE(delivered_at, '')
+			 FROM webhook_deliveries WHERE endpoint_id = ?
+			 ORDER BY id DESC LIMIT 100`,
+			id,
+		)
+		if err != nil {
+			logger.Error("Error querying webhook deliveries: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+		defer rows.Close()
+
+		deliveries := []WebhookDelivery{}
+		for rows.Next() {
+			var delivery WebhookDelivery
+			if err = rows.Scan(
+				&delivery.DeliveryID, &delivery.EventID, &delivery.Event, &delivery.Status,
+				&delivery.Attempts, &delivery.ResponseCode, &delivery.LastError,
+				&delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.DeliveredAt,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			deliveries = append(deliveries, delivery)
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		util.WriteJSON(w, map[string]interface{}{
+			"status":     "ok",
+			"deliveries": deliveries,
+		})
+	}
+}
+
+func decodeDeliveryRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		DeliveryID string `json:"delivery_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	if !util.ValidateTransactionID(req.DeliveryID) {
+		util.WriteJSONError(w, errInvalidRequest)
+		return "", false
+	}
+
+	return req.DeliveryID, true
+}
+
+func WebhookDeliveryLog(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		deliveryID, ok := decodeDeliveryRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var id int64
+		var payload string
+		var delivery WebhookDelivery
+
+		err := db.QueryRow(
+			`SELECT d.id, d.delivery_id, d.event_id, d.event, d.status, d.attempts,
+			 COALESCE(d.response_code, 0), COALESCE(d.last_error, ''), COALESCE(d.next_attempt_at, ''),
+			 d.created_at, COALESCE(d.delivered_at, ''), d.payload
+			 FROM webhook_deliveries d
+			 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+			 WHERE d.delivery_id = ? AND e.user_id = ?`,
+			deliveryID, user.ID,
+		).Scan(
+			&id, &delivery.DeliveryID, &delivery.EventID, &delivery.Event, &delivery.Status,
+			&delivery.Attempts, &delivery.ResponseCode, &delivery.LastError,
+			&delivery.NextAttemptAt, &delivery.CreatedAt, &delivery.DeliveredAt, &payload,
+		)
+
+		if err == sql.ErrNoRows {
+			util.WriteJSONError(w, errDeliveryNotFound)
+			return
+		} else if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		rows, err := db.Query(
+			`SELECT attempt, COALESCE(response_code, 0), COALESCE(error, ''), duration_ms, attempted_at
+			 FROM webhook_attempts WHERE delivery_id = ? ORDER BY attempt`,
+			id,
+		)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+		defer rows.Close()
+
+		delivery.Log = []WebhookAttempt{}
+		for rows.Next() {
+			var attempt WebhookAttempt
+			if err = rows.Scan(
+				&attempt.Attempt, &attempt.ResponseCode, &attempt.Error,
+				&attempt.DurationMs, &attempt.AttemptedAt,
+			); err != nil {
+				util.WriteJSONError(w, errInternalErrorOccurred)
+				return
+			}
+
+			delivery.Log = append(delivery.Log, attempt)
+		}
+
+		if err = rows.Err(); err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		delivery.Payload = json.RawMessage(payload)
+		util.WriteJSON(w, map[string]interface{}{
+			"status":   "ok",
+			"delivery": delivery,
+		})
+	}
+}
+
+func WebhookRedeliver(db *sql.DB) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			util.WriteJSONError(w, errInvalidRequest)
+			return
+		}
+
+		user, authErr := authenticate(db, r)
+		if authErr != "" {
+			util.WriteJSONError(w, authErr)
+			return
+		}
+
+		deliveryID, ok := decodeDeliveryRequest(w, r)
+		if !ok {
+			return
+		}
+
+		redeliveryID, err := util.GenerateRandomIdentifier(256)
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		now := time.Now().UTC().Format(time.RFC3339)
+		res, err := db.Exec(
+			`INSERT INTO webhook_deliveries
+			 (delivery_id, event_id, endpoint_id, event, payload, status, next_attempt_at, created_at)
+			 SELECT ?, d.event_id, d.endpoint_id, d.event, d.payload, ?, ?, ?
+			 FROM webhook_deliveries d
+			 JOIN webhook_endpoints e ON e.id = d.endpoint_id
+			 WHERE d.delivery_id = ? AND e.user_id = ? AND e.active = 1`,
+			redeliveryID, deliveryPending, now, now, deliveryID, user.ID,
+		)
+		if err != nil {
+			logger.Error("Error scheduling webhook redelivery: %s", err.Error())
+			util.WriteJSONError(w, errInternalErrorOccurred)
+
+			return
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			util.WriteJSONError(w, errInternalErrorOccurred)
+			return
+		}
+
+		if rowsAffected == 0 {
+			util.WriteJSONError(w, errDeliveryNotFound)
+			return
+		}
+
+		util.WriteJSON(w, map[string]string{
+			"status":      "ok",
+			"delivery_id": redeliveryID,
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package interest
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/nthnn/ura/fx"
+	"github.com/nthnn/ura/money"
+)
+
+const (
+	MicroScale      = 1000000
+	defaultDayCount = 365
+	dayLayout       = "2006-01-02"
+	periodLayout    = "2006-01"
+)
+
+var (
+	ErrInvalidRate     = errors.New("annual rate must be a percentage between 0 and 100")
+	ErrInvalidDayCount = errors.New("day count must be 360, 365 or 366")
+)
+
+type Product struct {
+	Name       string       `json:"-"`
+	Currency   string       `json:"currency"`
+	AnnualRate string       `json:"annual_rate"`
+	MinBalance money.Amount `json:"min_balance"`
+	Tiers      []string     `json:"tiers"`
+	Start      string       `json:"start"`
+
+	rate  *big.Rat
+	start time.Time
+}
+
+type Config struct {
+	DayCount int                `json:"day_count"`
+	Products map[string]Product `json:"products"`
+}
+
+type Plan struct {
+	dayCount int
+	products []Product
+}
+
+func Default() *Plan {
+	return &Plan{dayCount: defaultDayCount}
+}
+
+func Load(config Config) (*Plan, error) {
+	plan := Default()
+	if config.DayCount != 0 {
+		if config.DayCount != 360 && config.DayCount != 365 && config.DayCount != 366 {
+			return nil, ErrInvalidDayCount
+		}
+
+		plan.dayCount = config.DayCount
+	}
+
+	for name, product := range config.Products {
+		product.Name = name
+		if product.Currency == "" {
+			product.Currency = money.BaseCurrency
+		}
+
+		if !fx.ValidCurrency(product.Currency) {
+			return nil, fmt.Errorf("interest product %s: invalid currency %q", name, product.Currency)
+		}
+
+		rate, ok := new(big.Rat).SetString(product.AnnualRate)
+		if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+			return nil, fmt.Errorf("interest product %s: %w", name, ErrInvalidRate)
+		}
+		product.rate = rate.Quo(rate, big.NewRat(100, 1))
+
+		if product.MinBalance < 0 {
+			return nil, fmt.Errorf("interest product %s: minimum balance cannot be negative", name)
+		}
+
+		if product.Start != "" {
+			start, err := time.Parse(dayLayout, product.Start)
+			if err != nil {
+				return nil, fmt.Errorf("interest product %s: invalid start date %q", name, product.Start)
+			}
+
+			product.start = start
+		}
+
+		plan.products = append(plan.products, product)
+	}
+
+	sort.Slice(plan.products, func(i, j int) bool {
+		return plan.products[i].Name < plan.products[j].Name
+	})
+
+	for i, a := range plan.products {
+		for _, b := range plan.products[i+1:] {
+			if a.Currency == b.Currency && a.overlaps(b) {
+				return nil, fmt.Errorf("interest products %s and %s overlap", a.Name, b.Name)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+func (p Product) overlaps(other Product) bool {
+	if len(p.Tiers) == 0 || len(other.Tiers) == 0 {
+		return true
+	}
+
+	for _, tier := range p.Tiers {
+		if other.Covers(tier) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Product) Covers(tier string) bool {
+	if len(p.Tiers) == 0 {
+		return true
+	}
+
+	for _, name := range p.Tiers {
+		if name == tier {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Product) StartDate() (time.Time, bool) {
+	return p.start, !p.start.IsZero()
+}
+
+func (p Product) Rate() string {
+	return p.AnnualRate
+}
+
+func (p *Plan) Products() []Product {
+	return p.products
+}
+
+func (p *Plan) Accrue(product Product, balance money.Amount) int64 {
+	if balance <= 0 || balance < product.MinBalance || product.rate == nil {
+		return 0
+	}
+
+	micros := new(big.Rat).SetInt64(int64(balance))
+	micros.Mul(micros, big.NewRat(MicroScale, 1))
+	micros.Mul(micros, product.rate)
+	micros.Quo(micros, big.NewRat(int64(p.dayCount), 1))
+
+	return new(big.Int).Quo(micros.Num(), micros.Denom()).Int64()
+}
+
+func Payable(micros int64) money.Amount {
+	return money.Amount(micros / MicroScale)
+}
+
+func Day(t time.Time) string {
+	return t.Format(dayLayout)
+}
+
+func ParseDay(s string, location *time.Location) (time.Time, error) {
+	return time.ParseInLocation(dayLayout, s, location)
+}
+
+func Period(day string) string {
+	return day[:len(periodLayout)]
+}
@@ -0,0 +1,162 @@
+package invoice
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nthnn/ura/money"
+)
+
+const (
+	MaxItems     = 100
+	MaxQuantity  = 1000000
+	dateLayout   = "2006-01-02"
+	maxReference = 32
+)
+
+var (
+	ErrNoItems            = errors.New("invoice must have between 1 and 100 line items")
+	ErrInvalidDescription = errors.New("line item description must be 1 to 140 characters")
+	ErrInvalidQuantity    = errors.New("line item quantity must be between 1 and 1000000")
+	ErrInvalidUnitPrice   = errors.New("line item unit price must be positive")
+	ErrInvalidTaxRate     = errors.New("tax rate must be between 0 and 100")
+	ErrInvalidReference   = errors.New("reference must be 1 to 32 letters, digits, dashes or slashes")
+	ErrInvalidDueDate     = errors.New("due date must be a YYYY-MM-DD date")
+	ErrTotalTooLarge      = errors.New("invoice total is too large")
+)
+
+type Item struct {
+	Description string
+	Quantity    int64
+	UnitPrice   money.Amount
+	TaxRate     string
+}
+
+type Line struct {
+	Item
+	Amount money.Amount
+	Tax    money.Amount
+}
+
+type Totals struct {
+	Lines    []Line
+	Subtotal money.Amount
+	Tax      money.Amount
+	Total    money.Amount
+}
+
+func parseRate(s string) (*big.Rat, error) {
+	if s == "" {
+		return new(big.Rat), nil
+	}
+
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+		return nil, ErrInvalidTaxRate
+	}
+
+	return rate.Quo(rate, big.NewRat(100, 1)), nil
+}
+
+func toAmount(value *big.Rat) (money.Amount, bool) {
+	value = new(big.Rat).Add(value, big.NewRat(1, 2))
+	whole := new(big.Int).Quo(value.Num(), value.Denom())
+
+	if !whole.IsInt64() {
+		return 0, false
+	}
+
+	return money.Amount(whole.Int64()), true
+}
+
+func Compute(items []Item) (Totals, error) {
+	var totals Totals
+	if len(items) == 0 || len(items) > MaxItems {
+		return totals, ErrNoItems
+	}
+
+	subtotal, tax := new(big.Rat), new(big.Rat)
+	for _, item := range items {
+		item.Description = strings.TrimSpace(item.Description)
+		if item.Description == "" || utf8.RuneCountInString(item.Description) > 140 {
+			return totals, ErrInvalidDescription
+		}
+
+		if item.Quantity < 1 || item.Quantity > MaxQuantity {
+			return totals, ErrInvalidQuantity
+		}
+
+		if item.UnitPrice <= 0 {
+			return totals, ErrInvalidUnitPrice
+		}
+
+		rate, err := parseRate(item.TaxRate)
+		if err != nil {
+			return totals, err
+		}
+
+		amount := new(big.Rat).SetInt64(int64(item.UnitPrice))
+		amount.Mul(amount, new(big.Rat).SetInt64(item.Quantity))
+
+		line := Line{Item: item}
+		var ok bool
+
+		if line.Amount, ok = toAmount(amount); !ok {
+			return totals, ErrTotalTooLarge
+		}
+
+		if line.Tax, ok = toAmount(new(big.Rat).Mul(amount, rate)); !ok {
+			return totals, ErrTotalTooLarge
+		}
+
+		subtotal.Add(subtotal, new(big.Rat).SetInt64(int64(line.Amount)))
+		tax.Add(tax, new(big.Rat).SetInt64(int64(line.Tax)))
+		totals.Lines = append(totals.Lines, line)
+	}
+
+	var ok bool
+	if totals.Subtotal, ok = toAmount(subtotal); !ok {
+		return totals, ErrTotalTooLarge
+	}
+
+	if totals.Tax, ok = toAmount(tax); !ok {
+		return totals, ErrTotalTooLarge
+	}
+
+	if totals.Total, ok = toAmount(new(big.Rat).Add(subtotal, tax)); !ok {
+		return totals, ErrTotalTooLarge
+	}
+
+	return totals, nil
+}
+
+func ValidReference(reference string) bool {
+	if reference == "" || len(reference) > maxReference {
+		return false
+	}
+
+	for i := 0; i < len(reference); i++ {
+		c := reference[i]
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && c != '-' && c != '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func ParseDueDate(s string, location *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation(dateLayout, s, location)
+	if err != nil {
+		return time.Time{}, ErrInvalidDueDate
+	}
+
+	return day, nil
+}
+
+func FormatDueDate(day time.Time) string {
+	return day.Format(dateLayout)
+}
@@ -0,0 +1,371 @@
+package ledger
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/nthnn/ura/money"
+)
+
+type Account struct {
+	Code     string
+	UserID   int64
+	Currency string
+}
+
+type Posting struct {
+	Account Account
+	Amount  money.Amount
+}
+
+type Movement struct {
+	EntryID       int64
+	TransactionID string
+	Description   string
+	CreatedAt     string
+	Amount        money.Amount
+}
+
+type Mismatch struct {
+	UserID   int64        `json:"user_id"`
+	Currency string       `json:"currency"`
+	Cached   money.Amount `json:"cached"`
+	Posted   money.Amount `json:"posted"`
+}
+
+const heldFunds = `SELECT COALESCE(SUM(amount), 0) FROM holds
+	WHERE user_id = ? AND currency = ? AND status = 'authorized' AND expires_at > ?`
+
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var (
+	BankCash    = Account{Code: "bank:cash"}
+	BankFees    = Account{Code: "bank:fees"}
+	BankOpening = Account{Code: "bank:opening"}
+
+	ErrEmptyEntry        = errors.New("journal entry needs at least two postings")
+	ErrInsufficientFunds = errors.New("wallet balance cannot go below zero")
+	ErrUnbalancedEntry   = errors.New("journal entry debits and credits do not balance")
+	ErrZeroPosting       = errors.New("journal entry contains a zero posting")
+)
+
+func Wallet(userID int64) Account {
+	return Account{
+		Code:   "wallet:" + strconv.FormatInt(userID, 10),
+		UserID: userID,
+	}
+}
+
+func WalletIn(userID int64, currency string) Account {
+	if currency == money.BaseCurrency {
+		return Wallet(userID)
+	}
+
+	return Account{
+		Code:     "wallet:" + strconv.FormatInt(userID, 10) + ":" + currency,
+		UserID:   userID,
+		Currency: currency,
+	}
+}
+
+func Exchange(currency string) Account {
+	return Account{
+		Code:     "bank:fx:" + currency,
+		Currency: currency,
+	}
+}
+
+func Fees(currency string) Account {
+	if currency == money.BaseCurrency {
+		return BankFees
+	}
+
+	return Account{
+		Code:     "bank:fees:" + currency,
+		Currency: currency,
+	}
+}
+
+func Interest(currency string) Account {
+	return Account{
+		Code:     "bank:interest:" + currency,
+		Currency: currency,
+	}
+}
+
+func Adjustments(currency string) Account {
+	return Account{
+		Code:     "bank:adjustments:" + currency,
+		Currency: currency,
+	}
+}
+
+func (a Account) currency() string {
+	if a.Currency == "" {
+		return money.BaseCurrency
+	}
+
+	return a.Currency
+}
+
+func Debit(account Account, amount money.Amount) Posting {
+	return Posting{Account: account, Amount: -amount}
+}
+
+func Credit(account Account, amount money.Amount) Posting {
+	return Posting{Account: account, Amount: amount}
+}
+
+func accountID(tx *sql.Tx, account Account, now string) (int64, error) {
+	var userID interface{}
+	if account.UserID != 0 {
+		userID = account.UserID
+	}
+
+	if _, err := tx.Exec(
+		"INSERT OR IGNORE INTO accounts (code, user_id, currency, created_at) VALUES (?, ?, ?, ?)",
+		account.Code, userID, account.currency(), now,
+	); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRow(
+		"SELECT id FROM accounts WHERE code = ?",
+		account.Code,
+	).Scan(&id)
+
+	return id, err
+}
+
+func Post(tx *sql.Tx, transactionID, description string, postings ...Posting) error {
+	if len(postings) < 2 {
+		return ErrEmptyEntry
+	}
+
+	sums := map[string]money.Amount{}
+	for _, posting := range postings {
+		if posting.Amount == 0 {
+			return ErrZeroPosting
+		}
+
+		sums[posting.Account.currency()] += posting.Amount
+	}
+
+	for _, sum := range sums {
+		if sum != 0 {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	res, err := tx.Exec(
+		"INSERT INTO journal_entries (transaction_id, description, created_at) VALUES (?, ?, ?)",
+		transactionID, description, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	entryID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, posting := range postings {
+		id, err := accountID(tx, posting.Account, now)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(
+			"INSERT INTO postings (entry_id, account_id, amount) VALUES (?, ?, ?)",
+			entryID, id, posting.Amount,
+		); err != nil {
+			return err
+		}
+
+		if posting.Account.UserID == 0 {
+			continue
+		}
+
+		if err = updateBalance(tx, posting); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Held(q queryer, userID int64, currency string) (money.Amount, error) {
+	var held money.Amount
+	err := q.QueryRow(
+		heldFunds,
+		userID, currency, time.Now().UTC().Format(time.RFC3339),
+	).Scan(&held)
+
+	return held, err
+}
+
+func updateBalance(tx *sql.Tx, posting Posting) error {
+	account := posting.Account
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	query := `UPDATE users SET balance_ura = balance_ura + ?
+		WHERE id = ? AND balance_ura + ? >= 0 AND (? > 0 OR balance_ura + ? >= (` + heldFunds + `))`
+	args := []interface{}{
+		posting.Amount, account.UserID, posting.Amount,
+		posting.Amount, posting.Amount, account.UserID, money.BaseCurrency, now,
+	}
+
+	if account.currency() != money.BaseCurrency {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO wallet_balances (user_id, currency, balance) VALUES (?, ?, 0)",
+			account.UserID, account.Currency,
+		); err != nil {
+			return err
+		}
+
+		query = `UPDATE wallet_balances SET balance = balance + ?
+			WHERE user_id = ? AND currency = ? AND balance + ? >= 0
+			AND (? > 0 OR balance + ? >= (` + heldFunds + `))`
+		args = []interface{}{
+			posting.Amount, account.UserID, account.Currency, posting.Amount,
+			posting.Amount, posting.Amount, account.UserID, account.Currency, now,
+		}
+	}
+
+	res, err := tx.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrInsufficientFunds
+	}
+
+	return nil
+}
+
+func Balance(db *sql.DB, account Account) (money.Amount, error) {
+	var balance money.Amount
+	err := db.QueryRow(
+		`SELECT COALESCE(SUM(p.amount), 0) FROM postings p
+		 JOIN accounts a ON a.id = p.account_id
+		 WHERE a.code = ?`,
+		account.Code,
+	).Scan(&balance)
+
+	return balance, err
+}
+
+func BalanceAt(db *sql.DB, account Account, before string) (money.Amount, error) {
+	var balance money.Amount
+	err := db.QueryRow(
+		`SELECT COALESCE(SUM(p.amount), 0) FROM postings p
+		 JOIN accounts a ON a.id = p.account_id
+		 JOIN journal_entries e ON e.id = p.entry_id
+		 WHERE a.code = ? AND e.created_at < ?`,
+		account.Code, before,
+	).Scan(&balance)
+
+	return balance, err
+}
+
+func Movements(db *sql.DB, account Account, from, to string) (money.Amount, []Movement, error) {
+	opening, err := BalanceAt(db, account, from)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := db.Query(
+		`SELECT e.id, COALESCE(e.transaction_id, ''), e.description, e.created_at, SUM(p.amount)
+		 FROM postings p
+		 JOIN accounts a ON a.id = p.account_id
+		 JOIN journal_entries e ON e.id = p.entry_id
+		 WHERE a.code = ? AND e.created_at >= ? AND e.created_at < ?
+		 GROUP BY e.id
+		 ORDER BY e.created_at, e.id`,
+		account.Code, from, to,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var movements []Movement
+	for rows.Next() {
+		var movement Movement
+		if err = rows.Scan(
+			&movement.EntryID,
+			&movement.TransactionID,
+			&movement.Description,
+			&movement.CreatedAt,
+			&movement.Amount,
+		); err != nil {
+			return 0, nil, err
+		}
+
+		movements = append(movements, movement)
+	}
+
+	return opening, movements, rows.Err()
+}
+
+func Verify(db *sql.DB) ([]Mismatch, error) {
+	var unbalanced int
+	if err := db.QueryRow(
+		`SELECT COUNT(*) FROM (
+			SELECT p.entry_id FROM postings p
+			JOIN accounts a ON a.id = p.account_id
+			GROUP BY p.entry_id, a.currency HAVING SUM(p.amount) != 0
+		 )`,
+	).Scan(&unbalanced); err != nil {
+		return nil, err
+	}
+
+	if unbalanced != 0 {
+		return nil, ErrUnbalancedEntry
+	}
+
+	rows, err := db.Query(
+		`SELECT u.id, 'URA', u.balance_ura, COALESCE(SUM(p.amount), 0)
+		 FROM users u
+		 LEFT JOIN accounts a ON a.user_id = u.id AND a.currency = 'URA'
+		 LEFT JOIN postings p ON p.account_id = a.id
+		 GROUP BY u.id
+		 HAVING u.balance_ura != COALESCE(SUM(p.amount), 0)
+		 UNION ALL
+		 SELECT w.user_id, w.currency, w.balance, COALESCE(SUM(p.amount), 0)
+		 FROM wallet_balances w
+		 LEFT JOIN accounts a ON a.user_id = w.user_id AND a.currency = w.currency
+		 LEFT JOIN postings p ON p.account_id = a.id
+		 GROUP BY w.user_id, w.currency
+		 HAVING w.balance != COALESCE(SUM(p.amount), 0)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mismatches []Mismatch
+	for rows.Next() {
+		var mismatch Mismatch
+		if err = rows.Scan(&mismatch.UserID, &mismatch.Currency, &mismatch.Cached, &mismatch.Posted); err != nil {
+			return nil, err
+		}
+
+		mismatches = append(mismatches, mismatch)
+	}
+
+	return mismatches, rows.Err()
+}
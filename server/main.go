@@ -3,16 +3,27 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/nthnn/ura/audit"
+	"github.com/nthnn/ura/calendar"
 	"github.com/nthnn/ura/db"
+	"github.com/nthnn/ura/fee"
+	"github.com/nthnn/ura/handler"
+	"github.com/nthnn/ura/interest"
+	"github.com/nthnn/ura/ledger"
 	"github.com/nthnn/ura/logger"
 	"github.com/nthnn/ura/mux"
+	"github.com/nthnn/ura/policy"
+	"github.com/nthnn/ura/scheduler"
 )
 
 type Config struct {
@@ -23,11 +34,18 @@ type Config struct {
 		Base string `json:"base"`
 		Dir  string `json:"dir"`
 	} `json:"root"`
+	Calendar      calendar.Config `json:"calendar"`
+	Policy        policy.Config   `json:"policy"`
+	Interest      interest.Config `json:"interest"`
+	Fees          fee.Config      `json:"fees"`
+	VoucherTTL    policy.Duration `json:"voucher_ttl"`
+	ExchangeRates string          `json:"exchange_rates"`
 }
 
 var (
 	database *sql.DB
 	config   Config
+	jobs     *scheduler.Scheduler
 )
 
 func loadConfig(configPath string) error {
@@ -41,6 +59,69 @@ func loadConfig(configPath string) error {
 	if err = decoder.Decode(&config); err != nil {
 		return err
 	}
+
+	configDir := filepath.Dir(configPath)
+	config.Calendar.Holidays = resolveConfigPath(configDir, config.Calendar.Holidays)
+	config.ExchangeRates = resolveConfigPath(configDir, config.ExchangeRates)
+
+	return nil
+}
+
+func resolveConfigPath(configDir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(configDir, path)
+}
+
+func loadPolicies() error {
+	businessCalendar, err := calendar.Load(config.Calendar)
+	if err != nil {
+		return err
+	}
+	handler.UseCalendar(businessCalendar)
+
+	limitPolicy, err := policy.Load(config.Policy)
+	if err != nil {
+		return err
+	}
+	handler.UsePolicy(limitPolicy)
+
+	interestPlan, err := interest.Load(config.Interest)
+	if err != nil {
+		return err
+	}
+
+	for _, product := range interestPlan.Products() {
+		for _, tier := range product.Tiers {
+			if !limitPolicy.HasTier(tier) {
+				return fmt.Errorf("interest product %s: unknown tier %q", product.Name, tier)
+			}
+		}
+	}
+	handler.UseInterest(interestPlan)
+
+	feeSchedule, err := fee.Load(config.Fees)
+	if err != nil {
+		return err
+	}
+
+	for _, tier := range feeSchedule.Tiers() {
+		if !limitPolicy.HasTier(tier) {
+			return fmt.Errorf("fee schedule: unknown tier %q", tier)
+		}
+	}
+	handler.UseFees(feeSchedule)
+
+	if config.VoucherTTL.Duration < 0 {
+		return errors.New("voucher ttl cannot be negative")
+	}
+
+	if config.VoucherTTL.Duration != 0 {
+		handler.UseVoucherTTL(config.VoucherTTL.Duration)
+	}
+
 	return nil
 }
 
@@ -51,6 +132,25 @@ func initServer() {
 		panic("Failed to initialize database: " + err.Error())
 	}
 
+	if mismatches, err := ledger.Verify(database); err != nil {
+		logger.Error("Ledger verification failed: %s", err.Error())
+	} else if len(mismatches) != 0 {
+		logger.Error("Ledger has %d mismatched balance(s), run \"ura ledger-verify\".", len(mismatches))
+	}
+
+	if report, err := audit.Verify(database); err != nil {
+		logger.Error("Audit log verification failed: %s", err.Error())
+	} else if len(report.Breaks) != 0 {
+		logger.Error("Audit log has %d break(s), run \"ura audit-verify\".", len(report.Breaks))
+	}
+
+	if config.ExchangeRates != "" {
+		if err = handler.LoadExchangeRates(database, config.ExchangeRates); err != nil {
+			panic("Failed to load exchange rates: " + err.Error())
+		}
+		logger.Info("Loaded exchange rates from %s.", config.ExchangeRates)
+	}
+
 	mux.Initialize(config.Address, config.Port)
 	logger.Info("Starting server on %s:%d.", config.Address, config.Port)
 
@@ -59,6 +159,17 @@ func initServer() {
 
 	mux.RootDirectory(config.Root.Base, config.Root.Dir)
 	logger.Info("Serving static files from %s/%s.", config.Root.Base, config.Root.Dir)
+
+	jobs = scheduler.New()
+	jobs.Every("standing-orders", time.Minute, handler.RunStandingOrders(database))
+	jobs.Every("interest", time.Hour, handler.RunInterest(database))
+	jobs.Every("hold-expiry", time.Minute, handler.RunHoldExpiry(database))
+	jobs.Every("payment-request-expiry", time.Minute, handler.RunPaymentRequestExpiry(database))
+	jobs.Every("voucher-expiry", time.Minute, handler.RunVoucherExpiry(database))
+	jobs.Every("webhooks", 15*time.Second, handler.RunWebhooks(database))
+	jobs.Every("account-closures", time.Minute, handler.RunAccountClosures(database))
+	jobs.Start()
+	logger.Info("Started background scheduler.")
 }
 
 func runServer() {
@@ -101,12 +212,24 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := loadPolicies(); err != nil {
+		logger.Error("Error loading policies from config.json: %s", err.Error())
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
 
 		logger.Info("Received signal: %s", sig.String())
 		mux.Stop()
+		if jobs != nil {
+			jobs.Stop()
+		}
 
 		done <- true
 	}()
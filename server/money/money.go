@@ -0,0 +1,105 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Amount int64
+
+const (
+	BaseCurrency = "URA"
+
+	Scale       = 100
+	scaleDigits = 2
+)
+
+var errInvalidAmount = errors.New("invalid amount")
+
+func FromUnits(units int64) Amount {
+	return Amount(units * Scale)
+}
+
+func Parse(s string) (Amount, error) {
+	intPart, fracPart, hasDot := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, errInvalidAmount
+	}
+
+	if hasDot && fracPart == "" {
+		return 0, errInvalidAmount
+	}
+
+	if len(fracPart) > scaleDigits {
+		return 0, errInvalidAmount
+	}
+
+	for _, part := range []string{intPart, fracPart} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return 0, errInvalidAmount
+			}
+		}
+	}
+
+	var units, minor int64
+	var err error
+
+	if intPart != "" {
+		units, err = strconv.ParseInt(intPart, 10, 64)
+		if err != nil || units > math.MaxInt64/Scale {
+			return 0, errInvalidAmount
+		}
+	}
+
+	if fracPart != "" {
+		fracPart += strings.Repeat("0", scaleDigits-len(fracPart))
+		minor, _ = strconv.ParseInt(fracPart, 10, 64)
+	}
+
+	if units*Scale > math.MaxInt64-minor {
+		return 0, errInvalidAmount
+	}
+
+	return Amount(units*Scale + minor), nil
+}
+
+func (a Amount) String() string {
+	sign := ""
+	value := int64(a)
+
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	minor := strconv.FormatInt(value%Scale, 10)
+	if len(minor) < scaleDigits {
+		minor = strings.Repeat("0", scaleDigits-len(minor)) + minor
+	}
+
+	return sign + strconv.FormatInt(value/Scale, 10) + "." + minor
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), "\"")
+	negative := strings.HasPrefix(text, "-")
+
+	amount, err := Parse(strings.TrimPrefix(text, "-"))
+	if err != nil {
+		return err
+	}
+
+	if negative {
+		amount = -amount
+	}
+
+	*a = amount
+	return nil
+}
@@ -0,0 +1,250 @@
+package mux
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/util"
+)
+
+var (
+	idempotencyKeyTTL = 24 * time.Hour
+	credentialFields  = []string{"session_token", "security_code", "secret"}
+
+	errInvalidIdempotencyKey    = "Invalid idempotency key"
+	errIdempotencyKeyReused     = "Idempotency key was already used with a different request"
+	errIdempotencyKeyInProgress = "Request with this idempotency key is still being processed"
+	errIdempotencyInternal      = "Internal error occurred"
+)
+
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	return rec.body.Write(data)
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.status == 0 {
+		rec.status = statusCode
+	}
+}
+
+func hashHex(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) == 0 || len(key) > 128 {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSuccessfulResponse(rec *responseRecorder) bool {
+	if rec.status < 200 || rec.status > 299 {
+		return false
+	}
+
+	if !strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") {
+		return true
+	}
+
+	var response struct {
+		Status string `json:"status"`
+	}
+
+	if err := json.Unmarshal(rec.body.Bytes(), &response); err != nil {
+		return false
+	}
+
+	return response.Status == "ok"
+}
+
+func redactCredentials(body []byte) []byte {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body
+	}
+
+	redacted := false
+	for _, field := range credentialFields {
+		if _, exists := response[field]; exists {
+			delete(response, field)
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return body
+	}
+
+	stripped, err := json.Marshal(response)
+	if err != nil {
+		return []byte(`{"status":"ok"}`)
+	}
+
+	return stripped
+}
+
+func writeStoredResponse(w http.ResponseWriter, status int, header http.Header, body []byte, replayed bool) {
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+
+	if replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func idempotent(db *sql.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		sessionToken := r.Header.Get("X-Session-Token")
+		securityCode := r.Header.Get("X-Security-Code")
+
+		if key == "" || sessionToken == "" || securityCode == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !validIdempotencyKey(key) {
+			util.WriteJSONError(w, errInvalidIdempotencyKey)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			util.WriteJSONError(w, errIdempotencyInternal)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := hashHex([]byte(sessionToken), []byte(securityCode))
+		requestHash := hashHex([]byte(r.Method), []byte(r.URL.Path), body)
+		now := time.Now().UTC()
+
+		if _, err = db.Exec(
+			"DELETE FROM idempotency_keys WHERE created_at < ?",
+			now.Add(-idempotencyKeyTTL).Format(time.RFC3339),
+		); err != nil {
+			logger.Error("Error expiring idempotency keys: %s", err.Error())
+		}
+
+		res, err := db.Exec(
+			`INSERT OR IGNORE INTO idempotency_keys (scope, idempotency_key, request_hash, created_at)
+			 VALUES (?, ?, ?, ?)`,
+			scope, key, requestHash, now.Format(time.RFC3339),
+		)
+		if err != nil {
+			logger.Error("Error storing idempotency key: %s", err.Error())
+			util.WriteJSONError(w, errIdempotencyInternal)
+			return
+		}
+
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			util.WriteJSONError(w, errIdempotencyInternal)
+			return
+		}
+
+		if inserted == 0 {
+			var storedHash, storedHeaders string
+			var storedResponse sql.NullString
+			var storedStatus int
+
+			err = db.QueryRow(
+				`SELECT request_hash, response, response_status, response_headers
+				 FROM idempotency_keys WHERE scope = ? AND idempotency_key = ?`,
+				scope, key,
+			).Scan(&storedHash, &storedResponse, &storedStatus, &storedHeaders)
+
+			if err != nil {
+				util.WriteJSONError(w, errIdempotencyInternal)
+				return
+			}
+
+			if storedHash != requestHash {
+				util.WriteJSONError(w, errIdempotencyKeyReused)
+				return
+			}
+
+			if !storedResponse.Valid {
+				util.WriteJSONError(w, errIdempotencyKeyInProgress)
+				return
+			}
+
+			header := http.Header{}
+			if err = json.Unmarshal([]byte(storedHeaders), &header); err != nil {
+				util.WriteJSONError(w, errIdempotencyInternal)
+				return
+			}
+
+			writeStoredResponse(w, storedStatus, header, []byte(storedResponse.String), true)
+			return
+		}
+
+		recorder := &responseRecorder{header: http.Header{}}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		response := recorder.body.Bytes()
+		if isSuccessfulResponse(recorder) {
+			header, _ := json.Marshal(recorder.header)
+			_, err = db.Exec(
+				`UPDATE idempotency_keys SET response = ?, response_status = ?, response_headers = ?
+				 WHERE scope = ? AND idempotency_key = ?`,
+				string(redactCredentials(response)), recorder.status, string(header), scope, key,
+			)
+		} else {
+			_, err = db.Exec(
+				"DELETE FROM idempotency_keys WHERE scope = ? AND idempotency_key = ?",
+				scope, key,
+			)
+		}
+
+		if err != nil {
+			logger.Error("Error updating idempotency key: %s", err.Error())
+		}
+
+		writeStoredResponse(w, recorder.status, recorder.header, response, false)
+	})
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/nthnn/ura/handler"
 	"github.com/nthnn/ura/logger"
+	"github.com/nthnn/ura/rbac"
 	"github.com/nthnn/ura/util"
 )
 
@@ -27,11 +28,23 @@ func addEntryPoint(
 	muxServer.Handle(
 		path,
 		util.RateLimit(
-			http.HandlerFunc(callback(db)),
+			idempotent(
+				db,
+				http.HandlerFunc(callback(db)),
+			),
 		),
 	)
 }
 
+func addAuthorizedEntryPoint(
+	path string,
+	db *sql.DB,
+	permission rbac.Permission,
+	callback func(*sql.DB) func(http.ResponseWriter, *http.Request),
+) {
+	addEntryPoint(path, db, handler.Authorize(permission, callback))
+}
+
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
@@ -70,14 +83,69 @@ func Initialize(bindAddr string, port int16) {
 func InitializeEntryPoints(db *sql.DB) {
 	addEntryPoint("/api/user/create", db, handler.UserCreate)
 	addEntryPoint("/api/user/delete", db, handler.UserDelete)
+	addEntryPoint("/api/user/close", db, handler.AccountClose)
+	addEntryPoint("/api/user/close/cancel", db, handler.AccountCloseCancel)
 	addEntryPoint("/api/user/login", db, handler.UserLogin)
 	addEntryPoint("/api/user/logout", db, handler.UserLogout)
 
 	addEntryPoint("/api/payment/send", db, handler.PaymentProcess)
 	addEntryPoint("/api/payment/request", db, handler.PaymentRequest)
+	addEntryPoint("/api/payment/transfer", db, handler.PaymentTransfer)
+	addEntryPoint("/api/payment/preview", db, handler.PaymentPreview)
+	addEntryPoint("/api/payment/cancel", db, handler.PaymentCancel)
+	addEntryPoint("/api/payment/accept", db, handler.PaymentAccept)
+	addEntryPoint("/api/payment/decline", db, handler.PaymentDecline)
+	addEntryPoint("/api/payment/refund", db, handler.PaymentRefund)
+
+	addEntryPoint("/api/holds", db, handler.HoldList)
+	addEntryPoint("/api/holds/authorize", db, handler.HoldAuthorize)
+	addEntryPoint("/api/holds/capture", db, handler.HoldCapture)
+	addEntryPoint("/api/holds/void", db, handler.HoldVoid)
+	addEntryPoint("/api/merchant/register", db, handler.MerchantRegister)
+	addEntryPoint("/api/merchant/invoices", db, handler.InvoiceList)
+	addEntryPoint("/api/merchant/invoices/create", db, handler.InvoiceCreate)
+	addEntryPoint("/api/merchant/invoices/refund", db, handler.InvoiceRefund)
+	addEntryPoint("/api/invoices/view", db, handler.InvoiceView)
+	addEntryPoint("/api/webhooks", db, handler.WebhookList)
+	addEntryPoint("/api/webhooks/create", db, handler.WebhookCreate)
+	addEntryPoint("/api/webhooks/delete", db, handler.WebhookDelete)
+	addEntryPoint("/api/webhooks/deliveries", db, handler.WebhookDeliveries)
+	addEntryPoint("/api/webhooks/delivery", db, handler.WebhookDeliveryLog)
+	addEntryPoint("/api/webhooks/redeliver", db, handler.WebhookRedeliver)
+
+	addEntryPoint("/api/standing-orders", db, handler.StandingOrderList)
+	addEntryPoint("/api/standing-orders/create", db, handler.StandingOrderCreate)
+	addEntryPoint("/api/standing-orders/update", db, handler.StandingOrderUpdate)
+	addEntryPoint("/api/standing-orders/cancel", db, handler.StandingOrderCancel)
+	addEntryPoint("/api/standing-orders/history", db, handler.StandingOrderHistory)
+
+	addEntryPoint("/api/statement", db, handler.AccountStatement)
+
+	addEntryPoint("/api/notifications/read", db, handler.NotificationRead)
 
 	addEntryPoint("/api/withdraw", db, handler.Withdraw)
 	addEntryPoint("/api/cashin", db, handler.CashIn)
+	addEntryPoint("/api/fees/quote", db, handler.FeeQuote)
+
+	addAuthorizedEntryPoint("/api/agent/voucher", db, rbac.SettleVouchers, handler.AgentVoucherLookup)
+	addAuthorizedEntryPoint("/api/agent/voucher/confirm", db, rbac.SettleVouchers, handler.AgentVoucherConfirm)
+	addAuthorizedEntryPoint("/api/agent/voucher/reject", db, rbac.SettleVouchers, handler.AgentVoucherReject)
+
+	addEntryPoint("/api/exchange/rates", db, handler.ExchangeRates)
+	addEntryPoint("/api/exchange/quote", db, handler.ExchangeQuote)
+	addEntryPoint("/api/wallet/convert", db, handler.WalletConvert)
+
+	addAuthorizedEntryPoint("/api/admin/reversal", db, rbac.ReversePayments, handler.AdminReversal)
+	addAuthorizedEntryPoint("/api/admin/rates/set", db, rbac.SetRates, handler.AdminSetExchangeRate)
+	addAuthorizedEntryPoint("/api/admin/accounts/status", db, rbac.FreezeAccounts, handler.AdminAccountStatus)
+	addAuthorizedEntryPoint("/api/admin/users/search", db, rbac.ReadUsers, handler.AdminUserSearch)
+	addAuthorizedEntryPoint("/api/admin/users/view", db, rbac.ReadUsers, handler.AdminUserView)
+	addAuthorizedEntryPoint("/api/admin/users/transactions", db, rbac.ReadHistory, handler.AdminUserTransactions)
+	addAuthorizedEntryPoint("/api/admin/users/role", db, rbac.AssignRoles, handler.AdminRoleAssign)
+	addAuthorizedEntryPoint("/api/admin/balances/adjust", db, rbac.AdjustBalances, handler.AdminBalanceAdjust)
+	addAuthorizedEntryPoint("/api/admin/ledger/verify", db, rbac.VerifyLedger, handler.AdminLedgerVerify)
+	addAuthorizedEntryPoint("/api/admin/audit", db, rbac.ReadAudit, handler.AdminAuditLog)
+	addAuthorizedEntryPoint("/api/admin/audit/verify", db, rbac.ReadAudit, handler.AdminAuditVerify)
 
 	muxServer.Handle(
 		"/api/user/session",
@@ -88,6 +156,31 @@ func InitializeEntryPoints(db *sql.DB) {
 		"/api/user/info",
 		http.HandlerFunc(handler.UserFetchInfo(db)),
 	)
+
+	muxServer.Handle(
+		"/api/transactions",
+		http.HandlerFunc(handler.TransactionList(db)),
+	)
+
+	muxServer.Handle(
+		"/api/payment/inbox",
+		http.HandlerFunc(handler.PaymentInbox(db)),
+	)
+
+	muxServer.Handle(
+		"/api/notifications",
+		http.HandlerFunc(handler.NotificationList(db)),
+	)
+
+	muxServer.Handle(
+		"/api/events/ticket",
+		http.HandlerFunc(handler.EventStreamTicket(db)),
+	)
+
+	muxServer.Handle(
+		"/api/events",
+		http.HandlerFunc(handler.EventStream(db)),
+	)
 }
 
 func RootDirectory(baseDir, folderName string) {
@@ -125,6 +218,8 @@ func Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	handler.CloseEventStreams()
+
 	if err := httpServer.Shutdown(ctx); err != nil {
 		logger.Error("Error shutting down HTTP server: %s", err.Error())
 	}
@@ -0,0 +1,238 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nthnn/ura/money"
+)
+
+const (
+	Payment  = "payment"
+	Receive  = "receive"
+	Withdraw = "withdraw"
+	CashIn   = "cashin"
+)
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+type Tier struct {
+	MaxPayment     money.Amount `json:"max_payment"`
+	MaxWithdraw    money.Amount `json:"max_withdraw"`
+	MaxCashIn      money.Amount `json:"max_cash_in"`
+	MaxReceived    money.Amount `json:"max_received"`
+	ReceivedWindow int          `json:"received_window"`
+	CashInCooldown Duration     `json:"cash_in_cooldown"`
+}
+
+type Config struct {
+	DefaultTier string          `json:"default_tier"`
+	Tiers       map[string]Tier `json:"tiers"`
+}
+
+type Activity struct {
+	Kind       string
+	Amount     money.Amount
+	Received   money.Amount
+	LastCashIn time.Time
+	Now        time.Time
+}
+
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return v.Message + " (rule " + v.Rule + ")"
+}
+
+type rule struct {
+	id    string
+	kind  string
+	check func(Tier, Activity) string
+}
+
+type Engine struct {
+	defaultTier string
+	tiers       map[string]Tier
+}
+
+var rules = []rule{
+	{
+		id:   "payment.max_amount",
+		kind: Payment,
+		check: func(tier Tier, activity Activity) string {
+			if activity.Amount > tier.MaxPayment {
+				return "Payment amount exceeds " + tier.MaxPayment.String() + " uro"
+			}
+			return ""
+		},
+	},
+	{
+		id:   "receive.max_received",
+		kind: Receive,
+		check: func(tier Tier, activity Activity) string {
+			if activity.Received >= tier.MaxReceived {
+				return fmt.Sprintf(
+					"Recipient received funds limit of %s uro exceeded in past %d business days",
+					tier.MaxReceived.String(), tier.ReceivedWindow,
+				)
+			}
+			return ""
+		},
+	},
+	{
+		id:   "withdraw.max_amount",
+		kind: Withdraw,
+		check: func(tier Tier, activity Activity) string {
+			if activity.Amount >= tier.MaxWithdraw {
+				return "Withdraw amount exceeds " + tier.MaxWithdraw.String() + " uro"
+			}
+			return ""
+		},
+	},
+	{
+		id:   "withdraw.max_received",
+		kind: Withdraw,
+		check: func(tier Tier, activity Activity) string {
+			if activity.Received >= tier.MaxReceived {
+				return fmt.Sprintf(
+					"Cannot withdraw after receiving %s uro in past %d business days",
+					tier.MaxReceived.String(), tier.ReceivedWindow,
+				)
+			}
+			return ""
+		},
+	},
+	{
+		id:   "cashin.max_amount",
+		kind: CashIn,
+		check: func(tier Tier, activity Activity) string {
+			if activity.Amount >= tier.MaxCashIn {
+				return "Cash in amount exceeds " + tier.MaxCashIn.String() + " uro"
+			}
+			return ""
+		},
+	},
+	{
+		id:   "cashin.cooldown",
+		kind: CashIn,
+		check: func(tier Tier, activity Activity) string {
+			if !activity.LastCashIn.IsZero() &&
+				activity.Now.Sub(activity.LastCashIn) < tier.CashInCooldown.Duration {
+				return "Cash in allowed only every " + formatDuration(tier.CashInCooldown.Duration)
+			}
+			return ""
+		},
+	},
+}
+
+func formatDuration(d time.Duration) string {
+	if d == time.Hour {
+		return "hour"
+	}
+
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+
+	return d.String()
+}
+
+func Default() *Engine {
+	return &Engine{
+		defaultTier: "basic",
+		tiers: map[string]Tier{
+			"basic": {
+				MaxPayment:     money.FromUnits(100000),
+				MaxWithdraw:    money.FromUnits(50000),
+				MaxCashIn:      money.FromUnits(100000),
+				MaxReceived:    money.FromUnits(50000),
+				ReceivedWindow: 2,
+				CashInCooldown: Duration{12 * time.Hour},
+			},
+		},
+	}
+}
+
+func Load(config Config) (*Engine, error) {
+	if len(config.Tiers) == 0 {
+		return Default(), nil
+	}
+
+	if _, exists := config.Tiers[config.DefaultTier]; !exists {
+		return nil, errors.New("default tier is not defined: " + config.DefaultTier)
+	}
+
+	for name, tier := range config.Tiers {
+		if tier.MaxPayment <= 0 || tier.MaxWithdraw <= 0 ||
+			tier.MaxCashIn <= 0 || tier.MaxReceived <= 0 {
+			return nil, errors.New("tier " + name + " must have positive limits")
+		}
+
+		if tier.ReceivedWindow < 0 || tier.CashInCooldown.Duration < 0 {
+			return nil, errors.New("tier " + name + " has a negative window")
+		}
+	}
+
+	return &Engine{
+		defaultTier: config.DefaultTier,
+		tiers:       config.Tiers,
+	}, nil
+}
+
+func (e *Engine) HasTier(name string) bool {
+	_, exists := e.tiers[name]
+	return exists
+}
+
+func (e *Engine) TierName(name string) string {
+	if e.HasTier(name) {
+		return name
+	}
+
+	return e.defaultTier
+}
+
+func (e *Engine) Tier(name string) Tier {
+	return e.tiers[e.TierName(name)]
+}
+
+func (e *Engine) Evaluate(tierName string, activity Activity) *Violation {
+	tier := e.Tier(tierName)
+	for _, rule := range rules {
+		if rule.kind != activity.Kind {
+			continue
+		}
+
+		if message := rule.check(tier, activity); message != "" {
+			return &Violation{Rule: rule.id, Message: message}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package rbac
+
+type Permission string
+
+const (
+	Customer = "customer"
+	Agent    = "agent"
+	Support  = "support"
+	Admin    = "admin"
+	Auditor  = "auditor"
+
+	SettleVouchers  Permission = "vouchers.settle"
+	ReadUsers       Permission = "users.read"
+	ReadHistory     Permission = "history.read"
+	FreezeAccounts  Permission = "accounts.freeze"
+	AdjustBalances  Permission = "balances.adjust"
+	ReversePayments Permission = "payments.reverse"
+	SetRates        Permission = "rates.set"
+	AssignRoles     Permission = "roles.assign"
+	VerifyLedger    Permission = "ledger.verify"
+	ReadAudit       Permission = "audit.read"
+)
+
+var (
+	Roles = []string{Customer, Agent, Support, Admin, Auditor}
+
+	grants = map[string][]Permission{
+		Customer: {},
+		Agent:    {SettleVouchers},
+		Support:  {ReadUsers, ReadHistory, FreezeAccounts},
+		Auditor:  {ReadUsers, ReadHistory, VerifyLedger, ReadAudit},
+		Admin: {
+			ReadUsers, ReadHistory, FreezeAccounts, AdjustBalances,
+			ReversePayments, SetRates, AssignRoles, VerifyLedger, ReadAudit,
+		},
+	}
+)
+
+func ValidRole(role string) bool {
+	_, exists := grants[role]
+	return exists
+}
+
+func Staff(role string) bool {
+	return role == Support || role == Admin || role == Auditor
+}
+
+func Allows(role string, permission Permission) bool {
+	for _, granted := range grants[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Permissions(role string) []Permission {
+	return append([]Permission{}, grants[role]...)
+}
@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	Once    = "once"
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+var ErrInvalidRecurrence = errors.New("recurrence must be once, daily, weekly or monthly")
+
+func ValidRecurrence(recurrence string) bool {
+	switch recurrence {
+	case Once, Daily, Weekly, Monthly:
+		return true
+	}
+
+	return false
+}
+
+func Occurrence(start time.Time, recurrence string, n int) (time.Time, error) {
+	switch recurrence {
+	case Once:
+		if n != 0 {
+			return time.Time{}, nil
+		}
+		return start, nil
+
+	case Daily:
+		return start.AddDate(0, 0, n), nil
+
+	case Weekly:
+		return start.AddDate(0, 0, 7*n), nil
+
+	case Monthly:
+		year, month, day := start.Date()
+		first := time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, start.Location())
+		lastDay := first.AddDate(0, 1, -1).Day()
+
+		if day > lastDay {
+			day = lastDay
+		}
+
+		return time.Date(
+			first.Year(), first.Month(), day,
+			start.Hour(), start.Minute(), start.Second(), 0,
+			start.Location(),
+		), nil
+	}
+
+	return time.Time{}, ErrInvalidRecurrence
+}
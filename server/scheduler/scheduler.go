@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nthnn/ura/logger"
+)
+
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(now time.Time) error
+}
+
+type Scheduler struct {
+	jobs []Job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler {
+	return &Scheduler{stop: make(chan struct{})}
+}
+
+func (s *Scheduler) Every(name string, interval time.Duration, run func(now time.Time) error) {
+	s.jobs = append(s.jobs, Job{
+		Name:     name,
+		Interval: interval,
+		Run:      run,
+	})
+}
+
+func (s *Scheduler) Start() {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(job)
+	}
+}
+
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(job Job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	s.runJob(job, time.Now().UTC())
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.runJob(job, now.UTC())
+		}
+	}
+}
+
+func (s *Scheduler) runJob(job Job, now time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Scheduled job %s panicked: %v", job.Name, r)
+		}
+	}()
+
+	if err := job.Run(now); err != nil {
+		logger.Error("Scheduled job %s failed: %s", job.Name, err.Error())
+	}
+}
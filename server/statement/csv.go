@@ -0,0 +1,47 @@
+package statement
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+	"time"
+)
+
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func writeCSV(w io.Writer, s Statement) error {
+	writer := csv.NewWriter(w)
+
+	records := [][]string{
+		{"date", "transaction_id", "description", "memo", "amount", "balance", "currency"},
+		{s.From.Format(time.RFC3339), "", "Opening balance", "", "", s.Opening.String(), s.Currency},
+	}
+
+	for _, line := range s.Lines {
+		records = append(records, []string{
+			line.Date.Format(time.RFC3339),
+			line.TransactionID,
+			csvText(line.Description),
+			csvText(line.Memo),
+			line.Amount.String(),
+			line.Balance.String(),
+			s.Currency,
+		})
+	}
+
+	records = append(records, []string{
+		s.To.Format(time.RFC3339), "", "Closing balance", "", "", s.Closing.String(), s.Currency,
+	})
+
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+
+	return writer.Error()
+}
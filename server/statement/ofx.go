@@ -0,0 +1,76 @@
+package statement
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const ofxTimeLayout = "20060102150405"
+
+var ofxEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func ofxText(s string, limit int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > limit {
+		s = string([]rune(s)[:limit])
+	}
+
+	return ofxEscaper.Replace(s)
+}
+
+func writeOFX(w io.Writer, s Statement) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprint(out, "OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\nSECURITY:NONE\r\n")
+	fmt.Fprint(out, "ENCODING:UTF-8\r\nCHARSET:NONE\r\nCOMPRESSION:NONE\r\n")
+	fmt.Fprint(out, "OLDFILEUID:NONE\r\nNEWFILEUID:NONE\r\n\r\n")
+
+	fmt.Fprint(out, "<OFX>\r\n<SIGNONMSGSRSV1>\r\n<SONRS>\r\n")
+	fmt.Fprint(out, "<STATUS>\r\n<CODE>0\r\n<SEVERITY>INFO\r\n</STATUS>\r\n")
+	fmt.Fprintf(out, "<DTSERVER>%s\r\n<LANGUAGE>ENG\r\n", s.GeneratedAt.UTC().Format(ofxTimeLayout))
+	fmt.Fprint(out, "</SONRS>\r\n</SIGNONMSGSRSV1>\r\n")
+
+	fmt.Fprint(out, "<BANKMSGSRSV1>\r\n<STMTTRNRS>\r\n<TRNUID>0\r\n")
+	fmt.Fprint(out, "<STATUS>\r\n<CODE>0\r\n<SEVERITY>INFO\r\n</STATUS>\r\n")
+	fmt.Fprintf(out, "<STMTRS>\r\n<CURDEF>%s\r\n", s.Currency)
+	fmt.Fprintf(out, "<BANKACCTFROM>\r\n<BANKID>URA\r\n<ACCTID>%s\r\n<ACCTTYPE>CHECKING\r\n</BANKACCTFROM>\r\n",
+		ofxText(s.Account, 22))
+
+	fmt.Fprintf(out, "<BANKTRANLIST>\r\n<DTSTART>%s\r\n<DTEND>%s\r\n",
+		s.From.UTC().Format(ofxTimeLayout),
+		s.To.UTC().Format(ofxTimeLayout),
+	)
+
+	for _, line := range s.Lines {
+		kind := "CREDIT"
+		if line.Amount < 0 {
+			kind = "DEBIT"
+		}
+
+		fmt.Fprintf(out, "<STMTTRN>\r\n<TRNTYPE>%s\r\n<DTPOSTED>%s\r\n<TRNAMT>%s\r\n<FITID>%s\r\n",
+			kind,
+			line.Date.UTC().Format(ofxTimeLayout),
+			line.Amount.String(),
+			ofxText(line.ID, 255),
+		)
+		fmt.Fprintf(out, "<NAME>%s\r\n", ofxText(line.Description, 32))
+
+		memo := line.TransactionID
+		if line.Memo != "" {
+			memo = line.Memo + " " + memo
+		}
+		fmt.Fprintf(out, "<MEMO>%s\r\n</STMTTRN>\r\n", ofxText(memo, 255))
+	}
+
+	fmt.Fprint(out, "</BANKTRANLIST>\r\n")
+	fmt.Fprintf(out, "<LEDGERBAL>\r\n<BALAMT>%s\r\n<DTASOF>%s\r\n</LEDGERBAL>\r\n",
+		s.Closing.String(),
+		s.To.UTC().Format(ofxTimeLayout),
+	)
+	fmt.Fprint(out, "</STMTRS>\r\n</STMTTRNRS>\r\n</BANKMSGSRSV1>\r\n</OFX>\r\n")
+
+	return out.Flush()
+}
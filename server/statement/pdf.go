@@ -0,0 +1,153 @@
+package statement
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+const pdfTimeLayout = "02/01/2006 15:04:05 MST"
+
+func writePDF(w io.Writer, s Statement) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.SetMargins(10, 10, 10)
+	pdf.SetAutoPageBreak(false, 15)
+	pdf.AliasNbPages("")
+
+	pdf.SetTitle("Ura Account Statement", false)
+	pdf.SetSubject("Official account statement", false)
+	pdf.SetAuthor(tr(s.Holder), false)
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.CellFormat(
+			0, 10,
+			"Generated on "+s.GeneratedAt.Format(pdfTimeLayout)+". Page "+
+				strconv.Itoa(pdf.PageNo())+" of {nb}.",
+			"", 0,
+			"C", false,
+			0, "",
+		)
+	})
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	left, _, right, bottom := pdf.GetMargins()
+	availableWidth := pageWidth - left - right
+
+	colWidths := []float64{
+		availableWidth * 0.22,
+		availableWidth * 0.42,
+		availableWidth * 0.18,
+		availableWidth * 0.18,
+	}
+
+	headers := []string{
+		"Date",
+		"Description",
+		"Amount (" + s.Currency + ")",
+		"Balance (" + s.Currency + ")",
+	}
+
+	fit := func(text string, width float64) string {
+		text = tr(text)
+		if pdf.GetStringWidth(text) <= width-2 {
+			return text
+		}
+
+		for len(text) > 0 && pdf.GetStringWidth(text+"...") > width-2 {
+			text = text[:len(text)-1]
+		}
+
+		return text + "..."
+	}
+
+	printTableHeader := func() {
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetFillColor(200, 200, 200)
+
+		for i, header := range headers {
+			pdf.CellFormat(colWidths[i], 6, header, "1", 0, "C", true, 0, "")
+		}
+
+		pdf.Ln(-1)
+	}
+
+	pdf.AddPage()
+
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 10, "Ura Account Statement", "", 1, "C", false, 0, "")
+
+	pdf.SetFont("Helvetica", "", 10)
+	period := s.From.Format("02/01/2006") + " to " + s.To.AddDate(0, 0, -1).Format("02/01/2006")
+	for _, row := range [][2]string{
+		{"Account holder", s.Holder},
+		{"Account", s.Account},
+		{"Currency", s.Currency},
+		{"Period", period},
+	} {
+		pdf.CellFormat(40, 5, row[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 5, tr(row[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(3)
+
+	pdf.SetFont("Helvetica", "B", 10)
+	for _, row := range [][2]string{
+		{"Opening balance", s.Opening.String()},
+		{"Total credits", s.Credits().String()},
+		{"Total debits", s.Debits().String()},
+		{"Closing balance", s.Closing.String()},
+	} {
+		pdf.CellFormat(40, 5, row[0], "", 0, "L", false, 0, "")
+		pdf.CellFormat(40, 5, row[1], "", 1, "R", false, 0, "")
+	}
+	pdf.Ln(5)
+
+	printTableHeader()
+
+	for i, line := range s.Lines {
+		if pdf.GetY()+10 > pageHeight-bottom {
+			pdf.AddPage()
+			printTableHeader()
+		}
+
+		fill := i%2 == 1
+		if fill {
+			pdf.SetFillColor(240, 240, 240)
+		} else {
+			pdf.SetFillColor(255, 255, 255)
+		}
+
+		description := line.Description
+		if line.Memo != "" {
+			description += " - " + line.Memo
+		}
+
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(colWidths[0], 5, line.Date.Format(pdfTimeLayout), "LR", 0, "L", fill, 0, "")
+		pdf.CellFormat(colWidths[1], 5, fit(description, colWidths[1]), "LR", 0, "L", fill, 0, "")
+		pdf.CellFormat(colWidths[2], 5, line.Amount.String(), "LR", 0, "R", fill, 0, "")
+		pdf.CellFormat(colWidths[3], 5, line.Balance.String(), "LR", 1, "R", fill, 0, "")
+
+		pdf.SetFont("Courier", "", 6)
+		pdf.CellFormat(colWidths[0], 4, "", "LRB", 0, "L", fill, 0, "")
+		pdf.CellFormat(
+			colWidths[1]+colWidths[2]+colWidths[3], 4,
+			line.TransactionID, "LRB", 1, "L", fill, 0, "",
+		)
+	}
+
+	if pdf.GetY()+6 > pageHeight-bottom {
+		pdf.AddPage()
+	}
+
+	pdf.SetFont("Helvetica", "B", 10)
+	pdf.SetFillColor(200, 200, 200)
+	pdf.CellFormat(colWidths[0]+colWidths[1]+colWidths[2], 6, "Closing balance", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(colWidths[3], 6, s.Closing.String(), "1", 1, "R", true, 0, "")
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,43 @@
+package statement
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const qifDateLayout = "01/02/2006"
+
+func qifText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func writeQIF(w io.Writer, s Statement) error {
+	out := bufio.NewWriter(w)
+	account := "Ura " + s.Currency
+
+	fmt.Fprint(out, "!Type:Bank\n")
+	fmt.Fprintf(out, "D%s\nT%s\nCX\nPOpening Balance\nL[%s]\n^\n",
+		s.From.Format(qifDateLayout),
+		s.Opening.String(),
+		account,
+	)
+
+	for _, line := range s.Lines {
+		fmt.Fprintf(out, "D%s\nT%s\nCX\nN%s\nP%s\n",
+			line.Date.Format(qifDateLayout),
+			line.Amount.String(),
+			qifText(line.ID),
+			qifText(line.Description),
+		)
+
+		memo := line.TransactionID
+		if line.Memo != "" {
+			memo = line.Memo + " " + memo
+		}
+		fmt.Fprintf(out, "M%s\n^\n", qifText(memo))
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,115 @@
+package statement
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/nthnn/ura/money"
+)
+
+type Format string
+
+const (
+	PDF Format = "pdf"
+	CSV Format = "csv"
+	OFX Format = "ofx"
+	QIF Format = "qif"
+)
+
+var ErrUnknownFormat = errors.New("unknown statement format")
+
+type Line struct {
+	ID            string
+	Date          time.Time
+	TransactionID string
+	Description   string
+	Memo          string
+	Amount        money.Amount
+	Balance       money.Amount
+}
+
+type Statement struct {
+	Holder      string
+	Account     string
+	Currency    string
+	From        time.Time
+	To          time.Time
+	Opening     money.Amount
+	Closing     money.Amount
+	Lines       []Line
+	GeneratedAt time.Time
+}
+
+func (s *Statement) Add(line Line) {
+	s.Closing += line.Amount
+	line.Balance = s.Closing
+
+	s.Lines = append(s.Lines, line)
+}
+
+func (s Statement) Credits() money.Amount {
+	var total money.Amount
+	for _, line := range s.Lines {
+		if line.Amount > 0 {
+			total += line.Amount
+		}
+	}
+
+	return total
+}
+
+func (s Statement) Debits() money.Amount {
+	var total money.Amount
+	for _, line := range s.Lines {
+		if line.Amount < 0 {
+			total -= line.Amount
+		}
+	}
+
+	return total
+}
+
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(s); format {
+	case PDF, CSV, OFX, QIF:
+		return format, nil
+	}
+
+	return "", ErrUnknownFormat
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case PDF:
+		return "application/pdf"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case OFX:
+		return "application/x-ofx"
+	case QIF:
+		return "application/qif"
+	}
+
+	return "application/octet-stream"
+}
+
+func (f Format) Filename(s Statement) string {
+	return "ura-statement-" + s.From.Format("20060102") + "-" +
+		s.To.AddDate(0, 0, -1).Format("20060102") + "." + string(f)
+}
+
+func Write(w io.Writer, format Format, s Statement) error {
+	switch format {
+	case PDF:
+		return writePDF(w, s)
+	case CSV:
+		return writeCSV(w, s)
+	case OFX:
+		return writeOFX(w, s)
+	case QIF:
+		return writeQIF(w, s)
+	}
+
+	return ErrUnknownFormat
+}
@@ -57,7 +57,7 @@ func (rl *RateLimiter) allow(key string) bool {
 	return true
 }
 
-func getClientIP(r *http.Request) string {
+func ClientIP(r *http.Request) string {
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		parts := strings.Split(xff, ",")
 		return strings.TrimSpace(parts[0])
@@ -75,7 +75,7 @@ var globalRateLimiter = newRateLimiter(5*time.Minute, 2*time.Second)
 
 func RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := getClientIP(r)
+		key := ClientIP(r)
 		if !globalRateLimiter.allow(key) {
 			WriteJSONError(w, "Too many requests")
 			return
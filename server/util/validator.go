@@ -21,28 +21,6 @@ func ValidateEmail(email string) bool {
 	return err == nil
 }
 
-func ValidateNumbers(s string) bool {
-	if s == "" {
-		return false
-	}
-
-	dotCount := 0
-	for _, ch := range s {
-		if ch == '.' {
-			dotCount++
-			if dotCount > 1 {
-				return false
-			}
-			continue
-		}
-		if ch < '0' || ch > '9' {
-			return false
-		}
-	}
-
-	return true
-}
-
 func ValidateSessionToken(s string) bool {
 	if len(s) != 64 {
 		return false
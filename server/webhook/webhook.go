@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	PaymentReceived = "payment.received"
+	PaymentSent     = "payment.sent"
+	PaymentReversed = "payment.reversed"
+	RefundIssued    = "refund.issued"
+	RefundReceived  = "refund.received"
+	InvoicePaid     = "invoice.paid"
+	VoucherSettled  = "voucher.settled"
+	VoucherExpired  = "voucher.expired"
+
+	AllEvents = "*"
+
+	SignatureHeader = "X-Ura-Signature"
+	EventHeader     = "X-Ura-Event"
+	DeliveryHeader  = "X-Ura-Delivery"
+
+	MaxAttempts = 8
+
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+var (
+	Events = []string{
+		PaymentReceived, PaymentSent, PaymentReversed, RefundIssued,
+		RefundReceived, InvoicePaid, VoucherSettled, VoucherExpired,
+	}
+
+	ErrDeliveryFailed   = errors.New("endpoint responded with a non-2xx status")
+	ErrForbiddenAddress = errors.New("endpoint resolves to a private or reserved address")
+	ErrUnresolvableHost = errors.New("endpoint host could not be resolved")
+	ErrConnectionFailed = errors.New("could not connect to endpoint")
+	ErrDeliveryTimedOut = errors.New("endpoint did not respond in time")
+	ErrInvalidEndpoint  = errors.New("endpoint URL is invalid")
+
+	reservedNetworks = []*net.IPNet{
+		{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+		{IP: net.IPv4(198, 18, 0, 0), Mask: net.CIDRMask(15, 32)},
+	}
+)
+
+type Result struct {
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+}
+
+func (r Result) OK() bool {
+	return r.Err == nil
+}
+
+func ValidEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidURL(raw string) bool {
+	if len(raw) > 2048 {
+		return false
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.User != nil {
+		return false
+	}
+
+	return parsed.Scheme == "https" || parsed.Scheme == "http"
+}
+
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func CheckHost(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ErrInvalidEndpoint
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return ErrUnresolvableHost
+	}
+
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+
+	return nil
+}
+
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return ErrForbiddenAddress
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return ErrForbiddenAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func classify(err error) error {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrForbiddenAddress):
+		return ErrForbiddenAddress
+	case errors.As(err, &dnsErr):
+		return ErrUnresolvableHost
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrDeliveryTimedOut
+	}
+
+	return ErrConnectionFailed
+}
+
+func Sign(secret string, timestamp int64, body []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Backoff(attempt int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
+}
+
+func Deliver(client *http.Client, endpoint, secret, deliveryID, event string, payload []byte) Result {
+	start := time.Now()
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return Result{Err: ErrInvalidEndpoint}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Ura-Webhooks/1.0")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, deliveryID)
+	req.Header.Set(SignatureHeader, Sign(secret, start.Unix(), payload))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return Result{Duration: time.Since(start), Err: classify(err)}
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	result := Result{StatusCode: res.StatusCode, Duration: time.Since(start)}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		result.Err = ErrDeliveryFailed
+	}
+
+	return result
+}
@@ -1,12 +1,20 @@
 import hashlib
+import os
 import requests
+import subprocess
+import sys
 import time
+import uuid
 
+from decimal import Decimal
 from rich.console import Console
 from rich.json import JSON
 
 BASE_URL = "http://localhost:5173"
+URA_BIN = os.environ.get("URA_BIN", "dist/ura")
+
 console = Console()
+failures = []
 
 def sleep_if_needed():
     time.sleep(2.0)
@@ -76,7 +84,8 @@ def payment_request(user_session, user_security, amount):
     url = f"{BASE_URL}/api/payment/request"
     headers = {
         "X-Session-Token": user_session,
-        "X-Security-Code": user_security
+        "X-Security-Code": user_security,
+        "Idempotency-Key": str(uuid.uuid4())
     }
     payload = {"amount": str(amount)}
 
@@ -92,7 +101,8 @@ def payment_send(user_session, user_security, transaction_id):
     url = f"{BASE_URL}/api/payment/send"
     headers = {
         "X-Session-Token": user_session,
-        "X-Security-Code": user_security
+        "X-Security-Code": user_security,
+        "Idempotency-Key": str(uuid.uuid4())
     }
     payload = {"transaction_id": transaction_id}
 
@@ -132,6 +142,217 @@ def user_logout(session_token):
     sleep_if_needed()
     return response.json()
 
+def api_post(title, path, payload=None, user=None):
+    url = f"{BASE_URL}{path}"
+    headers = {}
+
+    if user is not None:
+        headers["X-Session-Token"] = user["session_token"]
+        headers["X-Security-Code"] = user["security_code"]
+        headers["Idempotency-Key"] = str(uuid.uuid4())
+
+    response = requests.post(url, json=payload or {}, headers=headers)
+
+    console.print(f"[bold green]{title} response:[/bold green]")
+    console.print(JSON.from_data(response.json()))
+
+    sleep_if_needed()
+    return response.json()
+
+def amount(value):
+    return Decimal(str(value if value is not None else "NaN"))
+
+def check(condition, message):
+    if condition:
+        console.print(f"[bold green]PASS[/bold green] {message}")
+    else:
+        console.print(f"[bold red]FAIL[/bold red] {message}")
+        failures.append(message)
+
+def create_test_user(prefix):
+    username = f"{prefix}_{uuid.uuid4().hex[:8]}"
+    password = f"{username}@@"
+
+    create_user(username, f"{username}@example.com", password)
+    login = login_user(username, password)
+    login["username"] = username
+
+    return login
+
+def fund(user, agent, amount):
+    voucher = api_post(f"Cash in {amount} uro", "/api/cashin", {"amount": str(amount)}, user)
+    confirm = api_post(
+        "Confirm voucher",
+        "/api/agent/voucher/confirm",
+        {"transaction_id": voucher.get("transaction_id")},
+        agent
+    )
+
+    check(confirm.get("status") == "ok", f"{user['username']} funded with {amount} uro")
+
+def transfer(payer, recipient, amount, to_currency=""):
+    payload = {"recipient": recipient["username"], "amount": str(amount)}
+    if to_currency:
+        payload["to_currency"] = to_currency
+
+    return api_post(f"Transfer {amount} uro", "/api/payment/transfer", payload, payer)
+
+def test_partial_refund(agent):
+    console.print("\n[bold blue]=== Partial Refund and Over-Refund ===[/bold blue]")
+
+    payer = create_test_user("refund_payer")
+    payee = create_test_user("refund_payee")
+    fund(payer, agent, 1000)
+
+    payment = transfer(payer, payee, 100)
+    transaction_id = payment.get("transaction_id")
+
+    refund = api_post("Partial refund", "/api/payment/refund", {
+        "transaction_id": transaction_id,
+        "amount": "40",
+        "memo": "Partial refund"
+    }, payee)
+
+    check(refund.get("status") == "ok", "partial refund is accepted")
+    check(refund.get("related_transaction_id") == transaction_id, "refund is linked to the payment")
+    check(amount(refund.get("refundable")) == Decimal("60"), "refundable balance drops to 60")
+
+    over_refund = api_post("Over-refund", "/api/payment/refund", {
+        "transaction_id": transaction_id,
+        "amount": "70",
+        "memo": "Over-refund"
+    }, payee)
+
+    check(over_refund.get("status") == "error", "refund above the refundable balance is rejected")
+
+def test_targeted_request(agent):
+    console.print("\n[bold blue]=== Targeted Payment Request ===[/bold blue]")
+
+    requester = create_test_user("target_requester")
+    payer = create_test_user("target_payer")
+    stranger = create_test_user("target_stranger")
+    fund(payer, agent, 100)
+
+    request = api_post("Targeted payment request", "/api/payment/request", {
+        "amount": "25",
+        "payer": payer["username"]
+    }, requester)
+    transaction_id = request.get("transaction_id")
+
+    accept = api_post("Accept by wrong user", "/api/payment/accept", {"transaction_id": transaction_id}, stranger)
+    check(accept.get("status") == "error", "another user cannot accept a targeted request")
+
+    decline = api_post("Decline by wrong user", "/api/payment/decline", {"transaction_id": transaction_id}, stranger)
+    check(decline.get("status") == "error", "another user cannot decline a targeted request")
+
+    accept = api_post("Accept by payer", "/api/payment/accept", {"transaction_id": transaction_id}, payer)
+    check(accept.get("status") == "ok", "the addressed payer can accept the request")
+
+def test_hold_capture_and_void(agent):
+    console.print("\n[bold blue]=== Hold Capture and Void ===[/bold blue]")
+
+    payer = create_test_user("hold_payer")
+    merchant = create_test_user("hold_merchant")
+    fund(payer, agent, 200)
+
+    hold = api_post("Authorize hold", "/api/holds/authorize", {
+        "recipient": merchant["username"],
+        "amount": "50"
+    }, payer)
+    check(amount(hold.get("available")) == Decimal("150"), "authorized amount is reserved")
+
+    capture = api_post("Capture hold", "/api/holds/capture", {
+        "hold_id": hold.get("hold_id"),
+        "amount": "30"
+    }, merchant)
+    check(capture.get("status") == "ok", "merchant captures part of the hold")
+    check(amount(capture.get("released")) == Decimal("20"), "uncaptured remainder is released")
+
+    hold = api_post("Authorize hold", "/api/holds/authorize", {
+        "recipient": merchant["username"],
+        "amount": "20"
+    }, payer)
+
+    void = api_post("Void hold", "/api/holds/void", {"hold_id": hold.get("hold_id")}, payer)
+    check(void.get("status") == "ok", "payer voids an open hold")
+
+    info = user_info(payer["session_token"], payer["security_code"])
+    check(amount(info.get("user", {}).get("available_ura")) == Decimal("170"), "only the captured amount leaves the wallet")
+
+def test_cross_currency_payment(agent):
+    console.print("\n[bold blue]=== Cross-Currency Payment ===[/bold blue]")
+
+    payer = create_test_user("fx_payer")
+    payee = create_test_user("fx_payee")
+    fund(payer, agent, 100)
+
+    payment = transfer(payer, payee, 10, "USD")
+    check(payment.get("status") == "ok", "payment converted to USD is accepted")
+
+    history = api_post("Payer transactions", "/api/transactions", {"category": "outgoing"}, payer)
+    outgoing = [
+        transaction for transaction in history.get("transactions", [])
+        if transaction["transaction_id"] == payment.get("transaction_id")
+    ]
+
+    check(len(outgoing) == 1 and outgoing[0]["fx_rate"] != "", "payment records its exchange rate")
+    check(len(outgoing) == 1 and outgoing[0]["counter_currency"] == "USD", "payment records the counter currency")
+
+def test_transaction_pagination(agent):
+    console.print("\n[bold blue]=== Transaction Pagination and Filters ===[/bold blue]")
+
+    payer = create_test_user("page_payer")
+    payee = create_test_user("page_payee")
+    fund(payer, agent, 100)
+
+    for amount in (1, 2, 3):
+        transfer(payer, payee, amount)
+
+    first = api_post("First page", "/api/transactions", {"limit": "2"}, payer)
+    check(len(first.get("transactions", [])) == 2 and first.get("has_more"), "first page holds two rows and more remain")
+
+    second = api_post("Second page", "/api/transactions", {
+        "limit": "2",
+        "cursor": first.get("next_cursor")
+    }, payer)
+
+    seen = {transaction["transaction_id"] for transaction in first.get("transactions", [])}
+    check(
+        len(second.get("transactions", [])) == 2 and
+        not seen & {transaction["transaction_id"] for transaction in second.get("transactions", [])},
+        "second page continues without repeating rows"
+    )
+    check(not second.get("has_more"), "second page is the last one")
+
+    filtered = api_post("Outgoing transactions", "/api/transactions", {
+        "category": "outgoing",
+        "status": "processed"
+    }, payer)
+
+    check(
+        filtered.get("total") == 3 and
+        all(transaction["category"] == "outgoing" for transaction in filtered.get("transactions", [])),
+        "category and status filters return only processed outgoing payments"
+    )
+
+def run_end_to_end_cases():
+    agent = create_test_user("agent")
+    result = subprocess.run([URA_BIN, "role", agent["username"], "agent"])
+
+    if result.returncode != 0:
+        console.print("[bold red]Cannot assign agent role; set URA_BIN to the server binary.[/bold red]")
+        sys.exit(1)
+
+    test_partial_refund(agent)
+    test_targeted_request(agent)
+    test_hold_capture_and_void(agent)
+    test_cross_currency_payment(agent)
+    test_transaction_pagination(agent)
+
+    if failures:
+        console.print(f"\n[bold red]{len(failures)} check(s) failed.[/bold red]")
+        sys.exit(1)
+
 def main():
     console.print("[bold blue]=== Creating Users ===[/bold blue]")
 
@@ -183,3 +404,4 @@ def main():
 
 if __name__ == "__main__":
     main()
+    run_end_to_end_cases()
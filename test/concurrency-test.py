@@ -0,0 +1,168 @@
+import hashlib
+import os
+import random
+import requests
+import subprocess
+import sys
+import uuid
+
+from concurrent.futures import ThreadPoolExecutor
+from decimal import Decimal
+from rich.console import Console
+
+BASE_URL = os.environ.get("URA_URL", "http://localhost:5173")
+URA_BIN = os.environ.get("URA_BIN", "dist/ura")
+WORKERS = 16
+
+console = Console()
+failures = []
+
+def hash_sha512(input_str: str):
+    return hashlib.sha512(input_str.encode()).hexdigest()
+
+def client_address():
+    return "10.%d.%d.%d" % (
+        random.randint(0, 255),
+        random.randint(0, 255),
+        random.randint(1, 254)
+    )
+
+def post(path, payload=None, user=None):
+    headers = {"X-Forwarded-For": client_address()}
+    if user is not None:
+        headers["X-Session-Token"] = user["session_token"]
+        headers["X-Security-Code"] = user["security_code"]
+
+    response = requests.post(f"{BASE_URL}{path}", json=payload or {}, headers=headers)
+    return response.json()
+
+def create_user(prefix):
+    username = f"{prefix}_{uuid.uuid4().hex[:8]}"
+    password = hash_sha512(username)
+
+    post("/api/user/create", {
+        "username": username,
+        "email": f"{username}@example.com",
+        "password": password
+    })
+
+    login = post("/api/user/login", {"username": username, "password": password})
+    login["username"] = username
+
+    return login
+
+def balance(user):
+    return Decimal(str(post("/api/user/info", user=user)["user"]["balance_ura"]))
+
+def run_parallel(calls):
+    with ThreadPoolExecutor(max_workers=WORKERS) as executor:
+        futures = [executor.submit(call) for call in calls]
+        return [future.result() for future in futures]
+
+def count_ok(responses):
+    return sum(1 for response in responses if response.get("status") == "ok")
+
+def check(condition, message):
+    if condition:
+        console.print(f"[bold green]PASS[/bold green] {message}")
+    else:
+        console.print(f"[bold red]FAIL[/bold red] {message}")
+        failures.append(message)
+
+def fund(user, agent, amount):
+    voucher = post("/api/cashin", {"amount": str(amount)}, user)
+    post("/api/agent/voucher/confirm", {"transaction_id": voucher["transaction_id"]}, agent)
+
+def test_parallel_payments(agent):
+    console.print("\n[bold blue]=== Parallel payments from one payer ===[/bold blue]")
+
+    payer = create_user("payer")
+    fund(payer, agent, 1000)
+
+    recipients = [create_user("recipient") for _ in range(10)]
+    requests_ids = [
+        post("/api/payment/request", {"amount": "300"}, recipient)["transaction_id"]
+        for recipient in recipients
+    ]
+
+    responses = run_parallel([
+        (lambda tid=tid: post("/api/payment/send", {"transaction_id": tid}, payer))
+        for tid in requests_ids
+    ])
+
+    paid = count_ok(responses)
+    remaining = balance(payer)
+    received = sum(balance(recipient) for recipient in recipients)
+
+    check(paid == 3, f"exactly 3 of 10 payments succeeded (got {paid})")
+    check(remaining == Decimal("100"), f"payer kept 100 uro (got {remaining})")
+    check(received == Decimal("900"), f"recipients received 900 uro (got {received})")
+
+def test_double_payment(agent):
+    console.print("\n[bold blue]=== Same request paid by many payers ===[/bold blue]")
+
+    recipient = create_user("recipient")
+    payers = [create_user("payer") for _ in range(8)]
+    for payer in payers:
+        fund(payer, agent, 100)
+
+    tid = post("/api/payment/request", {"amount": "50"}, recipient)["transaction_id"]
+    responses = run_parallel([
+        (lambda payer=payer: post("/api/payment/send", {"transaction_id": tid}, payer))
+        for payer in payers
+    ])
+
+    paid = count_ok(responses)
+    total = sum(balance(payer) for payer in payers)
+
+    check(paid == 1, f"request was paid exactly once (got {paid})")
+    check(balance(recipient) == Decimal("50"), "recipient credited once")
+    check(total == Decimal("750"), f"only one payer was debited (total {total})")
+
+def test_parallel_withdraw_settlement(agent):
+    console.print("\n[bold blue]=== Parallel withdraw settlement ===[/bold blue]")
+
+    user = create_user("withdrawer")
+    fund(user, agent, 500)
+
+    vouchers = run_parallel([
+        (lambda: post("/api/withdraw", {"amount": "200"}, user))
+        for _ in range(6)
+    ])
+
+    issued = [voucher["transaction_id"] for voucher in vouchers if voucher.get("status") == "ok"]
+    responses = run_parallel([
+        (lambda tid=tid: post("/api/agent/voucher/confirm", {"transaction_id": tid}, agent))
+        for tid in issued
+    ])
+
+    settled = count_ok(responses)
+    remaining = balance(user)
+
+    check(settled <= 2, f"at most 2 withdrawals settled (got {settled})")
+    check(remaining >= 0, f"balance never went negative (got {remaining})")
+    check(remaining == Decimal("500") - Decimal("200") * settled, "balance matches settled withdrawals")
+
+def main():
+    agent = create_user("agent")
+    result = subprocess.run([URA_BIN, "role", agent["username"], "agent"])
+
+    if result.returncode != 0:
+        console.print("[bold red]Cannot assign agent role; set URA_BIN to the server binary.[/bold red]")
+        sys.exit(1)
+
+    test_parallel_payments(agent)
+    test_double_payment(agent)
+    test_parallel_withdraw_settlement(agent)
+
+    result = subprocess.run([URA_BIN, "ledger-verify"])
+    check(result.returncode == 0, "ledger balances match postings")
+
+    if failures:
+        console.print(f"\n[bold red]{len(failures)} check(s) failed.[/bold red]")
+        sys.exit(1)
+
+    console.print("\n[bold green]All concurrency checks passed.[/bold green]")
+
+if __name__ == "__main__":
+    main()